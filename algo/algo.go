@@ -23,7 +23,8 @@ it is not guaranteed to find the occurrence with the highest score.
 FuzzyMatchV2 implements a modified version of Smith-Waterman algorithm to find
 the optimal solution (highest score) according to the scoring criteria. Unlike
 the original algorithm, omission or mismatch of a character in the pattern is
-not allowed.
+not allowed. FuzzyMatchTypos allows a bounded number of them, along with
+transpositions, at the cost of a penalty for each edit.
 
 Performance
 -----------
@@ -42,6 +43,10 @@ Scoring criteria
 - We prefer matches at special positions, such as the start of a word, or
   uppercase character in camelCase words.
 
+- The start of a word is worth more after a whitespace character than after
+  a delimiter such as "/" or ":", which is worth more than after any other
+  non-word character. The amounts depend on the scoring scheme.
+
 - That is, we prefer an occurrence of the pattern with more characters
   matching at special positions, even if the total match length is longer.
     e.g. "fuzzyfinder" vs. "fuzzy-finder" on "ff"
@@ -79,7 +84,9 @@ Scoring criteria
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"log/slog"
 	"strings"
 	"unicode"
 	"unicode/utf8"
@@ -87,6 +94,9 @@ import (
 	"github.com/bookreport/fzflib/util"
 )
 
+// DEBUG has no effect.
+//
+// Deprecated: Use Scheme.WithLogger to log the score matrices of FuzzyMatchV2.
 var DEBUG bool
 
 func indexAt(index int, max int, forward bool) int {
@@ -143,7 +153,9 @@ const (
 type charClass int
 
 const (
-	charNonWord charClass = iota
+	charWhite charClass = iota
+	charNonWord
+	charDelimiter
 	charLower
 	charUpper
 	charLetter
@@ -174,18 +186,11 @@ func alloc32(offset int, slab *util.Slab, size int) (int, []int32) {
 	return offset, make([]int32, size)
 }
 
-func charClassOfAscii(char rune) charClass {
-	if char >= 'a' && char <= 'z' {
-		return charLower
-	} else if char >= 'A' && char <= 'Z' {
-		return charUpper
-	} else if char >= '0' && char <= '9' {
-		return charNumber
-	}
-	return charNonWord
+func (s *Scheme) charClassOfAscii(char rune) charClass {
+	return s.asciiClasses[char]
 }
 
-func charClassOfNonAscii(char rune) charClass {
+func (s *Scheme) charClassOfNonAscii(char rune) charClass {
 	if unicode.IsLower(char) {
 		return charLower
 	} else if unicode.IsUpper(char) {
@@ -194,36 +199,63 @@ func charClassOfNonAscii(char rune) charClass {
 		return charNumber
 	} else if unicode.IsLetter(char) {
 		return charLetter
+	} else if unicode.IsSpace(char) {
+		return charWhite
+	} else if strings.ContainsRune(s.delimiters, char) {
+		return charDelimiter
 	}
 	return charNonWord
 }
 
-func charClassOf(char rune) charClass {
+func (s *Scheme) charClassOf(char rune) charClass {
 	if char <= unicode.MaxASCII {
-		return charClassOfAscii(char)
+		return s.charClassOfAscii(char)
 	}
-	return charClassOfNonAscii(char)
+	return s.charClassOfNonAscii(char)
+}
+
+func (s *Scheme) bonusFor(prevClass charClass, class charClass) int16 {
+	return s.bonusMatrix[prevClass][class]
 }
 
-func bonusFor(prevClass charClass, class charClass) int16 {
-	if prevClass == charNonWord && class != charNonWord {
-		// Word boundary
-		return bonusBoundary
-	} else if prevClass == charLower && class == charUpper ||
+// computeBonus returns the bonus point for a character of the class that
+// follows a character of prevClass. It is precomputed into the bonus matrix
+// of the scheme.
+func (s *Scheme) computeBonus(prevClass charClass, class charClass) int16 {
+	if class > charNonWord {
+		switch prevClass {
+		case charWhite:
+			// Word boundary after whitespace
+			return s.bonusBoundaryWhite
+		case charDelimiter:
+			// Word boundary after a delimiter character
+			return s.bonusBoundaryDelimiter
+		case charNonWord:
+			// Word boundary
+			return bonusBoundary
+		}
+	}
+
+	if prevClass == charLower && class == charUpper ||
 		prevClass != charNumber && class == charNumber {
 		// camelCase letter123
 		return bonusCamel123
-	} else if class == charNonWord {
+	}
+
+	switch class {
+	case charNonWord, charDelimiter:
 		return bonusNonWord
+	case charWhite:
+		return s.bonusBoundaryWhite
 	}
 	return 0
 }
 
-func bonusAt(input *util.Chars, idx int) int16 {
+func (s *Scheme) bonusAt(input *util.Chars, idx int) int16 {
 	if idx == 0 {
-		return bonusBoundary
+		return s.bonusBoundaryWhite
 	}
-	return bonusFor(charClassOf(input.Get(idx-1)), charClassOf(input.Get(idx)))
+	return s.bonusFor(s.charClassOf(input.Get(idx-1)), s.charClassOf(input.Get(idx)))
 }
 
 func normalizeRune(r rune) rune {
@@ -302,43 +334,47 @@ func asciiFuzzyIndex(input *util.Chars, pattern []rune, caseSensitive bool) int
 	return firstIdx
 }
 
-func debugV2(T []rune, pattern []rune, F []int32, lastIdx int, H []int16, C []int16) {
+// formatV2 returns the score matrix (H) and the lengths of the consecutive
+// chunks (C) of FuzzyMatchV2 as a table
+func formatV2(T []rune, pattern []rune, F []int32, lastIdx int, H []int16, C []int16) string {
+	var sb strings.Builder
 	width := lastIdx - int(F[0]) + 1
 
 	for i, f := range F {
 		I := i * width
 		if i == 0 {
-			fmt.Print("  ")
+			sb.WriteString("  ")
 			for j := int(f); j <= lastIdx; j++ {
-				fmt.Printf(" " + string(T[j]) + " ")
+				sb.WriteString(" " + string(T[j]) + " ")
 			}
-			fmt.Println()
+			sb.WriteString("\n")
 		}
-		fmt.Print(string(pattern[i]) + " ")
+		sb.WriteString(string(pattern[i]) + " ")
 		for idx := int(F[0]); idx < int(f); idx++ {
-			fmt.Print(" 0 ")
+			sb.WriteString(" 0 ")
 		}
 		for idx := int(f); idx <= lastIdx; idx++ {
-			fmt.Printf("%2d ", H[i*width+idx-int(F[0])])
+			fmt.Fprintf(&sb, "%2d ", H[i*width+idx-int(F[0])])
 		}
-		fmt.Println()
+		sb.WriteString("\n")
 
-		fmt.Print("  ")
+		sb.WriteString("  ")
 		for idx, p := range C[I : I+width] {
 			if idx+int(F[0]) < int(F[i]) {
 				p = 0
 			}
 			if p > 0 {
-				fmt.Printf("%2d ", p)
+				fmt.Fprintf(&sb, "%2d ", p)
 			} else {
-				fmt.Print("   ")
+				sb.WriteString("   ")
 			}
 		}
-		fmt.Println()
+		sb.WriteString("\n")
 	}
+	return sb.String()
 }
 
-func FuzzyMatchV2(caseSensitive bool, normalize bool, forward bool, input *util.Chars, pattern []rune, withPos bool, slab *util.Slab) (Result, *[]int) {
+func (s *Scheme) FuzzyMatchV2(caseSensitive bool, normalize bool, forward bool, input *util.Chars, pattern []rune, withPos bool, slab *util.Slab) (Result, *[]int) {
 	// Assume that pattern is given in lowercase if case-insensitive.
 	// First check if there's a match and calculate bonus for each position.
 	// If the input string is too long, consider finding the matching chars in
@@ -352,7 +388,7 @@ func FuzzyMatchV2(caseSensitive bool, normalize bool, forward bool, input *util.
 	// Since O(nm) algorithm can be prohibitively expensive for large input,
 	// we fall back to the greedy algorithm.
 	if slab != nil && N*M > cap(slab.I16) {
-		return FuzzyMatchV1(caseSensitive, normalize, forward, input, pattern, withPos, slab)
+		return s.FuzzyMatchV1(caseSensitive, normalize, forward, input, pattern, withPos, slab)
 	}
 
 	// Phase 1. Optimized search for ASCII string
@@ -377,18 +413,18 @@ func FuzzyMatchV2(caseSensitive bool, normalize bool, forward bool, input *util.
 	// Phase 2. Calculate bonus for each point
 	maxScore, maxScorePos := int16(0), 0
 	pidx, lastIdx := 0, 0
-	pchar0, pchar, prevH0, prevClass, inGap := pattern[0], pattern[0], int16(0), charNonWord, false
+	pchar0, pchar, prevH0, prevClass, inGap := pattern[0], pattern[0], int16(0), s.initialClass, false
 	Tsub := T[idx:]
 	H0sub, C0sub, Bsub := H0[idx:][:len(Tsub)], C0[idx:][:len(Tsub)], B[idx:][:len(Tsub)]
 	for off, char := range Tsub {
 		var class charClass
 		if char <= unicode.MaxASCII {
-			class = charClassOfAscii(char)
+			class = s.charClassOfAscii(char)
 			if !caseSensitive && class == charUpper {
 				char += 32
 			}
 		} else {
-			class = charClassOfNonAscii(char)
+			class = s.charClassOfNonAscii(char)
 			if !caseSensitive && class == charUpper {
 				char = unicode.To(unicode.LowerCase, char)
 			}
@@ -398,7 +434,7 @@ func FuzzyMatchV2(caseSensitive bool, normalize bool, forward bool, input *util.
 		}
 
 		Tsub[off] = char
-		bonus := bonusFor(prevClass, class)
+		bonus := s.bonusFor(prevClass, class)
 		Bsub[off] = bonus
 		prevClass = class
 
@@ -417,7 +453,7 @@ func FuzzyMatchV2(caseSensitive bool, normalize bool, forward bool, input *util.
 			C0sub[off] = 1
 			if M == 1 && (forward && score > maxScore || !forward && score >= maxScore) {
 				maxScore, maxScorePos = score, idx+off
-				if forward && bonus == bonusBoundary {
+				if forward && bonus >= bonusBoundary {
 					break
 				}
 			}
@@ -486,11 +522,14 @@ func FuzzyMatchV2(caseSensitive bool, normalize bool, forward bool, input *util.
 				s1 = Hdiag[off] + scoreMatch
 				b := Bsub[off]
 				consecutive = Cdiag[off] + 1
-				// Break consecutive chunk
-				if b == bonusBoundary {
-					consecutive = 1
-				} else if consecutive > 1 {
-					b = util.Max16(b, util.Max16(bonusConsecutive, B[col-int(consecutive)+1]))
+				if consecutive > 1 {
+					fb := B[col-int(consecutive)+1]
+					// Break consecutive chunk
+					if b >= bonusBoundary && b > fb {
+						consecutive = 1
+					} else {
+						b = util.Max16(b, util.Max16(bonusConsecutive, fb))
+					}
 				}
 				if s1+b < s2 {
 					s1 += Bsub[off]
@@ -510,8 +549,10 @@ func FuzzyMatchV2(caseSensitive bool, normalize bool, forward bool, input *util.
 		}
 	}
 
-	if DEBUG {
-		debugV2(T, pattern, F, lastIdx, H, C)
+	if s.logger != nil && s.logger.Enabled(context.Background(), slog.LevelDebug) {
+		s.logger.LogAttrs(context.Background(), slog.LevelDebug, "fzflib: FuzzyMatchV2 score matrix",
+			slog.String("pattern", string(pattern)),
+			slog.String("matrix", formatV2(T, pattern, F, lastIdx, H, C)))
 	}
 
 	// Phase 4. (Optional) Backtrace to find character positions
@@ -541,7 +582,9 @@ func FuzzyMatchV2(caseSensitive bool, normalize bool, forward bool, input *util.
 				}
 				i--
 			}
-			preferMatch = C[I+j0] > 1 || I+width+j0+1 < len(C) && C[I+width+j0+1] > 0
+			// The next row is only filled in from its first occurrence, the rest of
+			// it may hold stale values from the previous use of the slab
+			preferMatch = C[I+j0] > 1 || I+width+j0+1 < len(C) && j+1 >= int(F[I/width+1]) && C[I+width+j0+1] > 0
 			j--
 		}
 	}
@@ -552,16 +595,16 @@ func FuzzyMatchV2(caseSensitive bool, normalize bool, forward bool, input *util.
 }
 
 // Implement the same sorting criteria as V2
-func calculateScore(caseSensitive bool, normalize bool, text *util.Chars, pattern []rune, sidx int, eidx int, withPos bool) (int, *[]int) {
+func (s *Scheme) calculateScore(caseSensitive bool, normalize bool, text *util.Chars, pattern []rune, sidx int, eidx int, withPos bool) (int, *[]int) {
 	pidx, score, inGap, consecutive, firstBonus := 0, 0, false, 0, int16(0)
 	pos := posArray(withPos, len(pattern))
-	prevClass := charNonWord
+	prevClass := s.initialClass
 	if sidx > 0 {
-		prevClass = charClassOf(text.Get(sidx - 1))
+		prevClass = s.charClassOf(text.Get(sidx - 1))
 	}
 	for idx := sidx; idx < eidx; idx++ {
 		char := text.Get(idx)
-		class := charClassOf(char)
+		class := s.charClassOf(char)
 		if !caseSensitive {
 			if char >= 'A' && char <= 'Z' {
 				char += 32
@@ -578,12 +621,12 @@ func calculateScore(caseSensitive bool, normalize bool, text *util.Chars, patter
 				*pos = append(*pos, idx)
 			}
 			score += scoreMatch
-			bonus := bonusFor(prevClass, class)
+			bonus := s.bonusFor(prevClass, class)
 			if consecutive == 0 {
 				firstBonus = bonus
 			} else {
 				// Break consecutive chunk
-				if bonus == bonusBoundary {
+				if bonus >= bonusBoundary && bonus > firstBonus {
 					firstBonus = bonus
 				}
 				bonus = util.Max16(util.Max16(bonus, firstBonus), bonusConsecutive)
@@ -612,7 +655,7 @@ func calculateScore(caseSensitive bool, normalize bool, text *util.Chars, patter
 }
 
 // FuzzyMatchV1 performs fuzzy-match
-func FuzzyMatchV1(caseSensitive bool, normalize bool, forward bool, text *util.Chars, pattern []rune, withPos bool, slab *util.Slab) (Result, *[]int) {
+func (s *Scheme) FuzzyMatchV1(caseSensitive bool, normalize bool, forward bool, text *util.Chars, pattern []rune, withPos bool, slab *util.Slab) (Result, *[]int) {
 	if len(pattern) == 0 {
 		return Result{0, 0, 0}, nil
 	}
@@ -683,7 +726,7 @@ func FuzzyMatchV1(caseSensitive bool, normalize bool, forward bool, text *util.C
 			sidx, eidx = lenRunes-eidx, lenRunes-sidx
 		}
 
-		score, pos := calculateScore(caseSensitive, normalize, text, pattern, sidx, eidx, withPos)
+		score, pos := s.calculateScore(caseSensitive, normalize, text, pattern, sidx, eidx, withPos)
 		return Result{sidx, eidx, score}, pos
 	}
 	return Result{-1, -1, 0}, nil
@@ -698,7 +741,7 @@ func FuzzyMatchV1(caseSensitive bool, normalize bool, forward bool, text *util.C
 // bonus point, instead of stopping immediately after finding the first match.
 // The solution is much cheaper since there is only one possible alignment of
 // the pattern.
-func ExactMatchNaive(caseSensitive bool, normalize bool, forward bool, text *util.Chars, pattern []rune, withPos bool, slab *util.Slab) (Result, *[]int) {
+func (s *Scheme) ExactMatchNaive(caseSensitive bool, normalize bool, forward bool, text *util.Chars, pattern []rune, withPos bool, slab *util.Slab) (Result, *[]int) {
 	if len(pattern) == 0 {
 		return Result{0, 0, 0}, nil
 	}
@@ -734,14 +777,14 @@ func ExactMatchNaive(caseSensitive bool, normalize bool, forward bool, text *uti
 		pchar := pattern[pidx_]
 		if pchar == char {
 			if pidx_ == 0 {
-				bonus = bonusAt(text, index_)
+				bonus = s.bonusAt(text, index_)
 			}
 			pidx++
 			if pidx == lenPattern {
 				if bonus > bestBonus {
 					bestPos, bestBonus = index, bonus
 				}
-				if bonus == bonusBoundary {
+				if bonus >= bonusBoundary {
 					break
 				}
 				index -= pidx - 1
@@ -761,14 +804,14 @@ func ExactMatchNaive(caseSensitive bool, normalize bool, forward bool, text *uti
 			sidx = lenRunes - (bestPos + 1)
 			eidx = lenRunes - (bestPos - lenPattern + 1)
 		}
-		score, _ := calculateScore(caseSensitive, normalize, text, pattern, sidx, eidx, false)
+		score, _ := s.calculateScore(caseSensitive, normalize, text, pattern, sidx, eidx, false)
 		return Result{sidx, eidx, score}, nil
 	}
 	return Result{-1, -1, 0}, nil
 }
 
 // PrefixMatch performs prefix-match
-func PrefixMatch(caseSensitive bool, normalize bool, forward bool, text *util.Chars, pattern []rune, withPos bool, slab *util.Slab) (Result, *[]int) {
+func (s *Scheme) PrefixMatch(caseSensitive bool, normalize bool, forward bool, text *util.Chars, pattern []rune, withPos bool, slab *util.Slab) (Result, *[]int) {
 	if len(pattern) == 0 {
 		return Result{0, 0, 0}, nil
 	}
@@ -795,12 +838,12 @@ func PrefixMatch(caseSensitive bool, normalize bool, forward bool, text *util.Ch
 		}
 	}
 	lenPattern := len(pattern)
-	score, _ := calculateScore(caseSensitive, normalize, text, pattern, trimmedLen, trimmedLen+lenPattern, false)
+	score, _ := s.calculateScore(caseSensitive, normalize, text, pattern, trimmedLen, trimmedLen+lenPattern, false)
 	return Result{trimmedLen, trimmedLen + lenPattern, score}, nil
 }
 
 // SuffixMatch performs suffix-match
-func SuffixMatch(caseSensitive bool, normalize bool, forward bool, text *util.Chars, pattern []rune, withPos bool, slab *util.Slab) (Result, *[]int) {
+func (s *Scheme) SuffixMatch(caseSensitive bool, normalize bool, forward bool, text *util.Chars, pattern []rune, withPos bool, slab *util.Slab) (Result, *[]int) {
 	lenRunes := text.Length()
 	trimmedLen := lenRunes
 	if len(pattern) == 0 || !unicode.IsSpace(pattern[len(pattern)-1]) {
@@ -829,12 +872,12 @@ func SuffixMatch(caseSensitive bool, normalize bool, forward bool, text *util.Ch
 	lenPattern := len(pattern)
 	sidx := trimmedLen - lenPattern
 	eidx := trimmedLen
-	score, _ := calculateScore(caseSensitive, normalize, text, pattern, sidx, eidx, false)
+	score, _ := s.calculateScore(caseSensitive, normalize, text, pattern, sidx, eidx, false)
 	return Result{sidx, eidx, score}, nil
 }
 
 // EqualMatch performs equal-match
-func EqualMatch(caseSensitive bool, normalize bool, forward bool, text *util.Chars, pattern []rune, withPos bool, slab *util.Slab) (Result, *[]int) {
+func (s *Scheme) EqualMatch(caseSensitive bool, normalize bool, forward bool, text *util.Chars, pattern []rune, withPos bool, slab *util.Slab) (Result, *[]int) {
 	lenPattern := len(pattern)
 	if lenPattern == 0 {
 		return Result{-1, -1, 0}, nil
@@ -877,8 +920,8 @@ func EqualMatch(caseSensitive bool, normalize bool, forward bool, text *util.Cha
 		match = runesStr == string(pattern)
 	}
 	if match {
-		return Result{trimmedLen, trimmedLen + lenPattern, (scoreMatch+bonusBoundary)*lenPattern +
-			(bonusFirstCharMultiplier-1)*bonusBoundary}, nil
+		return Result{trimmedLen, trimmedLen + lenPattern, (scoreMatch+int(s.bonusBoundaryWhite))*lenPattern +
+			(bonusFirstCharMultiplier-1)*int(s.bonusBoundaryWhite)}, nil
 	}
 	return Result{-1, -1, 0}, nil
 }
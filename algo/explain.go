@@ -0,0 +1,77 @@
+package algo
+
+import (
+	"github.com/bookreport/fzflib/util"
+)
+
+// CharScore is the part of the score of a match that comes from one of the
+// matched characters
+type CharScore struct {
+	// Index is the index of the character in the text
+	Index int
+
+	// Char is the character as it appears in the text
+	Char rune
+
+	// Match is the score of matching the character
+	Match int
+
+	// Bonus is the word boundary or camelCase bonus of the position of the
+	// character, multiplied for the first character of the pattern
+	Bonus int
+
+	// Consecutive is the extra bonus of the character for being in a chunk of
+	// consecutive matches
+	Consecutive int
+
+	// Gap is the penalty of the unmatched characters before the character
+	Gap int
+}
+
+// Total returns the sum of the parts of the score
+func (c CharScore) Total() int {
+	return c.Match + c.Bonus + c.Consecutive + c.Gap
+}
+
+// ExplainScore breaks down the score of a match with the given sorted
+// positions into the parts that come from each matched character. The parts
+// add up to the score of FuzzyMatchV1, ExactMatchNaive, PrefixMatch,
+// SuffixMatch and RegexMatch. The positions of FuzzyMatchV2 are not always
+// the ones its score comes from, as its score matrix only keeps the latest
+// occurrence of the first character of the pattern in the first row while
+// its backtrace can pick an earlier one, so the parts can add up to a few
+// points more or less than its score. EqualMatch and FuzzyMatchTypos have
+// scores of their own.
+func (s *Scheme) ExplainScore(text *util.Chars, positions []int) []CharScore {
+	scores := make([]CharScore, 0, len(positions))
+	consecutive, firstBonus := 0, int16(0)
+	for pidx, idx := range positions {
+		score := CharScore{Index: idx, Char: text.Get(idx), Match: scoreMatch}
+		if pidx > 0 && idx > positions[pidx-1]+1 {
+			score.Gap = scoreGapStart + scoreGapExtention*(idx-positions[pidx-1]-2)
+			consecutive, firstBonus = 0, 0
+		}
+
+		prevClass := s.initialClass
+		if idx > 0 {
+			prevClass = s.charClassOf(text.Get(idx - 1))
+		}
+		bonus := s.bonusFor(prevClass, s.charClassOf(score.Char))
+		score.Bonus = int(bonus)
+		if consecutive == 0 {
+			firstBonus = bonus
+		} else {
+			// Break consecutive chunk
+			if bonus >= bonusBoundary && bonus > firstBonus {
+				firstBonus = bonus
+			}
+			score.Consecutive = int(util.Max16(util.Max16(bonus, firstBonus), bonusConsecutive) - bonus)
+		}
+		if pidx == 0 {
+			score.Bonus *= bonusFirstCharMultiplier
+		}
+		consecutive++
+		scores = append(scores, score)
+	}
+	return scores
+}
@@ -0,0 +1,106 @@
+package algo
+
+import (
+	"regexp"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/bookreport/fzflib/util"
+)
+
+// CompileRegex compiles the regular expression of a regex term. The
+// expression is matched case-insensitively unless caseSensitive is true. It
+// should be normalized with NormalizeRunes if the text is normalized.
+func CompileRegex(expr string, caseSensitive bool) (*regexp.Regexp, error) {
+	flags := ""
+	if !caseSensitive {
+		flags = "(?i)"
+	}
+	return regexp.Compile(flags + expr)
+}
+
+// RegexMatch performs regular expression match. Every character in the
+// matched substring is considered to be a matched character. Unlike the other
+// Algo functions, the pattern is not given in lowercase if caseSensitive is
+// false as it would change the meaning of escape sequences such as \S or \W.
+// The pattern is compiled on every call, use RegexpMatch to match many items
+// against the same expression.
+func (s *Scheme) RegexMatch(caseSensitive bool, normalize bool, forward bool, text *util.Chars, pattern []rune, withPos bool, slab *util.Slab) (Result, *[]int) {
+	re, err := CompileRegex(string(pattern), caseSensitive)
+	if err != nil {
+		return Result{-1, -1, 0}, nil
+	}
+	return s.RegexpMatch(re)(caseSensitive, normalize, forward, text, pattern, withPos, slab)
+}
+
+// RegexpMatch returns RegexMatch for an expression compiled with
+// CompileRegex. The pattern given to the returned Algo is ignored.
+func (s *Scheme) RegexpMatch(re *regexp.Regexp) Algo {
+	return func(caseSensitive bool, normalize bool, forward bool, text *util.Chars, pattern []rune, withPos bool, slab *util.Slab) (Result, *[]int) {
+		sidx, eidx := regexpFind(re, normalize, forward, text)
+		if sidx < 0 {
+			return Result{-1, -1, 0}, nil
+		}
+		return s.regexScore(caseSensitive, normalize, text, sidx, eidx, withPos)
+	}
+}
+
+// regexpFind returns the rune offsets of the first match of the expression in
+// the text, or of the last one if forward is false
+func regexpFind(re *regexp.Regexp, normalize bool, forward bool, text *util.Chars) (int, int) {
+	// The byte offsets of ASCII text are its rune offsets, which spares
+	// converting the text to a string
+	if text.IsBytes() {
+		bytes := text.Bytes()
+		var loc []int
+		if forward {
+			loc = re.FindIndex(bytes)
+		} else if all := re.FindAllIndex(bytes, -1); len(all) > 0 {
+			loc = all[len(all)-1]
+		}
+		if loc == nil {
+			return -1, -1
+		}
+		return loc[0], loc[1]
+	}
+
+	runes := text.ToRunes()
+	if normalize {
+		runes = NormalizeRunes(runes)
+	}
+	str := string(runes)
+
+	var loc []int
+	if forward {
+		loc = re.FindStringIndex(str)
+	} else if all := re.FindAllStringIndex(str, -1); len(all) > 0 {
+		loc = all[len(all)-1]
+	}
+	if loc == nil {
+		return -1, -1
+	}
+	sidx := utf8.RuneCountInString(str[:loc[0]])
+	return sidx, sidx + utf8.RuneCountInString(str[loc[0]:loc[1]])
+}
+
+// regexScore scores the match of a regular expression at [sidx, eidx)
+func (s *Scheme) regexScore(caseSensitive bool, normalize bool, text *util.Chars, sidx int, eidx int, withPos bool) (Result, *[]int) {
+	// Make calculateScore treat every character in the range as a match
+	matched := make([]rune, eidx-sidx)
+	for idx := range matched {
+		char := text.Get(sidx + idx)
+		if !caseSensitive {
+			if char >= 'A' && char <= 'Z' {
+				char += 32
+			} else if char > unicode.MaxASCII {
+				char = unicode.To(unicode.LowerCase, char)
+			}
+		}
+		if normalize {
+			char = normalizeRune(char)
+		}
+		matched[idx] = char
+	}
+	score, pos := s.calculateScore(caseSensitive, normalize, text, matched, sidx, eidx, withPos)
+	return Result{sidx, eidx, score}, pos
+}
@@ -0,0 +1,203 @@
+package algo
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/bookreport/fzflib/util"
+)
+
+// Scheme is a scoring scheme that determines the bonus points given to the
+// characters of a match depending on the characters that precede them. The
+// algorithms of a scheme are its methods, the package-level functions use
+// DefaultScheme.
+type Scheme struct {
+	name string
+
+	// rankPathname and groupLength are the ranking options of the scheme, see
+	// RanksPathname and GroupsLength
+	rankPathname bool
+	groupLength  bool
+
+	// delimiters are the characters of charDelimiter class
+	delimiters string
+
+	// bonusBoundaryWhite is the bonus point for the start of a word that
+	// follows a whitespace character or the start of the text
+	bonusBoundaryWhite int16
+
+	// bonusBoundaryDelimiter is the bonus point for the start of a word that
+	// follows one of the delimiters
+	bonusBoundaryDelimiter int16
+
+	// initialClass is the class assumed for the character before the text
+	initialClass charClass
+
+	// A minor optimization that can give 15%+ performance boost
+	asciiClasses [unicode.MaxASCII + 1]charClass
+
+	// A minor optimization that can give yet another 5% performance boost
+	bonusMatrix [charNumber + 1][charNumber + 1]int16
+
+	// logger receives the score matrices of FuzzyMatchV2 at debug level
+	logger *slog.Logger
+}
+
+// DefaultDelimiters are the delimiter characters of DefaultScheme and
+// HistoryScheme
+const DefaultDelimiters = "/,:;|"
+
+const whiteChars = " \t\n\v\f\r"
+
+var (
+	// DefaultScheme is the scoring scheme for generic input. The start of a
+	// word is worth the most after a whitespace character, then after one of
+	// DefaultDelimiters.
+	DefaultScheme = newScheme("default", DefaultDelimiters, bonusBoundary+2, bonusBoundary+1, charWhite)
+
+	// PathScheme is the scoring scheme for file paths. The start of a path
+	// component is worth more than the start of any other word, and the text
+	// is considered to start with a path separator.
+	PathScheme = newScheme("path", pathSeparators(), bonusBoundary, bonusBoundary+1, charDelimiter).withRanking(true, false)
+
+	// HistoryScheme is the scoring scheme for command history or any other
+	// input where the chronological order matters. The start of every word is
+	// worth the same, and ByLength makes less of a difference in the ranking
+	// of the items that are searched with it.
+	HistoryScheme = newScheme("history", DefaultDelimiters, bonusBoundary, bonusBoundary, charWhite).withRanking(false, true)
+)
+
+func newScheme(name string, delimiters string, bonusBoundaryWhite int16, bonusBoundaryDelimiter int16, initialClass charClass) *Scheme {
+	s := &Scheme{
+		name:                   name,
+		delimiters:             delimiters,
+		bonusBoundaryWhite:     bonusBoundaryWhite,
+		bonusBoundaryDelimiter: bonusBoundaryDelimiter,
+		initialClass:           initialClass}
+	for i := 0; i <= unicode.MaxASCII; i++ {
+		char := rune(i)
+		class := charNonWord
+		if char >= 'a' && char <= 'z' {
+			class = charLower
+		} else if char >= 'A' && char <= 'Z' {
+			class = charUpper
+		} else if char >= '0' && char <= '9' {
+			class = charNumber
+		} else if strings.ContainsRune(whiteChars, char) {
+			class = charWhite
+		} else if strings.ContainsRune(delimiters, char) {
+			class = charDelimiter
+		}
+		s.asciiClasses[i] = class
+	}
+	for i := charWhite; i <= charNumber; i++ {
+		for j := charWhite; j <= charNumber; j++ {
+			s.bonusMatrix[i][j] = s.computeBonus(i, j)
+		}
+	}
+	return s
+}
+
+// withRanking sets the ranking options of a new scheme
+func (s *Scheme) withRanking(rankPathname bool, groupLength bool) *Scheme {
+	s.rankPathname = rankPathname
+	s.groupLength = groupLength
+	return s
+}
+
+func pathSeparators() string {
+	if os.PathSeparator != '/' {
+		return "/" + string(os.PathSeparator)
+	}
+	return "/"
+}
+
+// WithDelimiters returns a copy of the scheme with the given delimiter
+// characters. The start of a word that follows one of them gets a higher
+// bonus than the start of a word that follows any other non-word character.
+func (s *Scheme) WithDelimiters(delimiters string) *Scheme {
+	scheme := newScheme(s.name, delimiters, s.bonusBoundaryWhite, s.bonusBoundaryDelimiter, s.initialClass)
+	scheme.withRanking(s.rankPathname, s.groupLength)
+	scheme.logger = s.logger
+	return scheme
+}
+
+// WithLogger returns a copy of the scheme whose FuzzyMatchV2 logs its score
+// matrix for every item to the logger at debug level. It is meant for
+// debugging the algorithm on a handful of items.
+func (s *Scheme) WithLogger(logger *slog.Logger) *Scheme {
+	scheme := *s
+	scheme.logger = logger
+	return &scheme
+}
+
+// ParseScheme returns the scoring scheme of the given name in the format of
+// the --scheme option of fzf, i.e. "default", "path" or "history"
+func ParseScheme(name string) (*Scheme, error) {
+	for _, scheme := range []*Scheme{DefaultScheme, PathScheme, HistoryScheme} {
+		if strings.ToLower(name) == scheme.name {
+			return scheme, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid scoring scheme: %s", name)
+}
+
+// String returns the name of the scheme, which is kept by WithDelimiters
+func (s *Scheme) String() string {
+	return s.name
+}
+
+// RanksPathname tells if the matches in the last component of a path should
+// rank higher, as with PathScheme
+func (s *Scheme) RanksPathname() bool {
+	return s.rankPathname
+}
+
+// GroupsLength tells if the items of similar length should tie on their
+// length, as with HistoryScheme
+func (s *Scheme) GroupsLength() bool {
+	return s.groupLength
+}
+
+// FuzzyMatchV1 is FuzzyMatchV1 of DefaultScheme
+func FuzzyMatchV1(caseSensitive bool, normalize bool, forward bool, text *util.Chars, pattern []rune, withPos bool, slab *util.Slab) (Result, *[]int) {
+	return DefaultScheme.FuzzyMatchV1(caseSensitive, normalize, forward, text, pattern, withPos, slab)
+}
+
+// FuzzyMatchV2 is FuzzyMatchV2 of DefaultScheme
+func FuzzyMatchV2(caseSensitive bool, normalize bool, forward bool, input *util.Chars, pattern []rune, withPos bool, slab *util.Slab) (Result, *[]int) {
+	return DefaultScheme.FuzzyMatchV2(caseSensitive, normalize, forward, input, pattern, withPos, slab)
+}
+
+// FuzzyMatchTypos is FuzzyMatchTypos of DefaultScheme
+func FuzzyMatchTypos(maxEdits int) Algo {
+	return DefaultScheme.FuzzyMatchTypos(maxEdits)
+}
+
+// ExactMatchNaive is ExactMatchNaive of DefaultScheme
+func ExactMatchNaive(caseSensitive bool, normalize bool, forward bool, text *util.Chars, pattern []rune, withPos bool, slab *util.Slab) (Result, *[]int) {
+	return DefaultScheme.ExactMatchNaive(caseSensitive, normalize, forward, text, pattern, withPos, slab)
+}
+
+// PrefixMatch is PrefixMatch of DefaultScheme
+func PrefixMatch(caseSensitive bool, normalize bool, forward bool, text *util.Chars, pattern []rune, withPos bool, slab *util.Slab) (Result, *[]int) {
+	return DefaultScheme.PrefixMatch(caseSensitive, normalize, forward, text, pattern, withPos, slab)
+}
+
+// SuffixMatch is SuffixMatch of DefaultScheme
+func SuffixMatch(caseSensitive bool, normalize bool, forward bool, text *util.Chars, pattern []rune, withPos bool, slab *util.Slab) (Result, *[]int) {
+	return DefaultScheme.SuffixMatch(caseSensitive, normalize, forward, text, pattern, withPos, slab)
+}
+
+// EqualMatch is EqualMatch of DefaultScheme
+func EqualMatch(caseSensitive bool, normalize bool, forward bool, text *util.Chars, pattern []rune, withPos bool, slab *util.Slab) (Result, *[]int) {
+	return DefaultScheme.EqualMatch(caseSensitive, normalize, forward, text, pattern, withPos, slab)
+}
+
+// RegexMatch is RegexMatch of DefaultScheme
+func RegexMatch(caseSensitive bool, normalize bool, forward bool, text *util.Chars, pattern []rune, withPos bool, slab *util.Slab) (Result, *[]int) {
+	return DefaultScheme.RegexMatch(caseSensitive, normalize, forward, text, pattern, withPos, slab)
+}
@@ -0,0 +1,157 @@
+package algo
+
+import (
+	"unicode"
+
+	"github.com/bookreport/fzflib/util"
+)
+
+// scoreTypo is added to the score for each edit of the pattern. An edit costs
+// more than the score of a matched character so that a match with fewer edits
+// is usually ranked higher.
+const scoreTypo = -scoreMatch * 2
+
+// typoMatrixMax is the maximum size of the edit matrix of typoMatch. It is
+// computed for every item that does not match without any edit, so longer
+// items are not matched with typos.
+const typoMatrixMax = 100 * 1024
+
+// FuzzyMatchTypos returns an Algo that performs fuzzy-match while tolerating
+// up to maxEdits typos in the pattern. An edit is a character of the pattern
+// that is missing or mismatched in the text, or two adjacent characters of the
+// pattern that are transposed in the text. Each edit lowers the score by
+// scoreTypo, and the positions only contain the characters that did match.
+//
+// A pattern that matches without any edit is matched by FuzzyMatchV2. To keep
+// short patterns from matching nearly everything, less than half of the
+// characters of a pattern can be edited regardless of maxEdits. The first
+// occurrence with the fewest edits is found, or the last one if forward is
+// false.
+func (s *Scheme) FuzzyMatchTypos(maxEdits int) Algo {
+	return func(caseSensitive bool, normalize bool, forward bool, text *util.Chars, pattern []rune, withPos bool, slab *util.Slab) (Result, *[]int) {
+		if res, pos := s.FuzzyMatchV2(caseSensitive, normalize, forward, text, pattern, withPos, slab); res.Start >= 0 {
+			return res, pos
+		}
+		maxEdits := util.Min(maxEdits, (len(pattern)-1)/2)
+		if maxEdits <= 0 || (len(pattern)+1)*(text.Length()+1) > typoMatrixMax {
+			return Result{-1, -1, 0}, nil
+		}
+		return s.typoMatch(caseSensitive, normalize, forward, text, pattern, maxEdits, withPos, slab)
+	}
+}
+
+// typoMatch finds the first occurrence of the pattern with the fewest edits.
+// D[i][j] is the minimum number of edits to match the first i characters of
+// the pattern within the first j characters of the text. Unmatched characters
+// of the text are not counted as they are gaps of the fuzzy match. The text
+// and the pattern are reversed to find the last occurrence if forward is
+// false.
+func (s *Scheme) typoMatch(caseSensitive bool, normalize bool, forward bool, text *util.Chars, pattern []rune, maxEdits int, withPos bool, slab *util.Slab) (Result, *[]int) {
+	M := len(pattern)
+	N := text.Length()
+	width := N + 1
+
+	_, T := alloc32(0, slab, N)
+	_, D := alloc16(0, slab, (M+1)*width)
+
+	for idx := 0; idx < N; idx++ {
+		char := text.Get(idx)
+		if !caseSensitive {
+			if char >= 'A' && char <= 'Z' {
+				char += 32
+			} else if char > unicode.MaxASCII {
+				char = unicode.To(unicode.LowerCase, char)
+			}
+		}
+		if normalize {
+			char = normalizeRune(char)
+		}
+		if forward {
+			T[idx] = char
+		} else {
+			T[N-idx-1] = char
+		}
+	}
+	if !forward {
+		reversed := make([]rune, M)
+		for idx, char := range pattern {
+			reversed[M-idx-1] = char
+		}
+		pattern = reversed
+	}
+
+	for j := 0; j <= N; j++ {
+		D[j] = 0
+	}
+	for i := 1; i <= M; i++ {
+		I, prev := i*width, (i-1)*width
+		D[I] = int16(i)
+		for j := 1; j <= N; j++ {
+			// Gap in the text
+			edits := D[I+j-1]
+			// Match
+			if T[j-1] == pattern[i-1] && D[prev+j-1] < edits {
+				edits = D[prev+j-1]
+			}
+			// Missing or mismatched character
+			if D[prev+j]+1 < edits {
+				edits = D[prev+j] + 1
+			}
+			// Transposition
+			if i > 1 && j > 1 && T[j-2] == pattern[i-1] && T[j-1] == pattern[i-2] && D[(i-2)*width+j-2]+1 < edits {
+				edits = D[(i-2)*width+j-2] + 1
+			}
+			D[I+j] = edits
+		}
+	}
+
+	last := M * width
+	edits := D[last+N]
+	if int(edits) > maxEdits {
+		return Result{-1, -1, 0}, nil
+	}
+	// Find the end of the first occurrence
+	end := N
+	for end > 0 && D[last+end-1] == edits {
+		end--
+	}
+
+	// Backtrace to find the matched characters, preferring the ones closer to
+	// the end of the occurrence
+	positions := []int{}
+	i, j := M, end
+	for i > 0 {
+		I := i * width
+		cur := D[I+j]
+		if j > 0 && T[j-1] == pattern[i-1] && D[I-width+j-1] == cur {
+			positions = append(positions, j-1)
+			i, j = i-1, j-1
+		} else if i > 1 && j > 1 && T[j-2] == pattern[i-1] && T[j-1] == pattern[i-2] && D[I-2*width+j-2]+1 == cur {
+			positions = append(positions, j-1, j-2)
+			i, j = i-2, j-2
+		} else if j > 0 && D[I+j-1] == cur {
+			j--
+		} else {
+			i--
+		}
+	}
+
+	// Map the positions back to the text, keeping them in ascending order
+	if !forward {
+		for left, right := 0, len(positions)-1; left <= right; left, right = left+1, right-1 {
+			positions[left], positions[right] = N-positions[right]-1, N-positions[left]-1
+		}
+	}
+
+	// Score the matched characters as a pattern of their own
+	matched := make([]rune, len(positions))
+	for idx, pos := range positions {
+		if !forward {
+			pos = N - pos - 1
+		}
+		matched[len(positions)-idx-1] = T[pos]
+	}
+	sidx, eidx := positions[len(positions)-1], positions[0]+1
+	score, pos := s.calculateScore(caseSensitive, normalize, text, matched, sidx, eidx, withPos)
+	return Result{sidx, eidx, score + int(edits)*scoreTypo}, pos
+}
@@ -0,0 +1,112 @@
+package fzflib
+
+import (
+	"sync"
+
+	"github.com/bookreport/fzflib/util"
+)
+
+// Corpus is a list of items that can be searched repeatedly. Items can be
+// pushed at any time, including while searches are running.
+type Corpus struct {
+	chunkList *chunkList
+	mutex     sync.Mutex
+	header    [][]byte
+	fields    []string
+}
+
+// NewCorpus returns a new empty Corpus
+func NewCorpus() *Corpus {
+	return newCorpus(nil, Delimiter{}, 0, false)
+}
+
+// newCorpus returns a new empty Corpus that transforms the items to the
+// fields of withNth split with the delimiter when they are pushed. The first
+// headerLines items are held aside as the header. If byFields is true, the
+// items are also split into the fields that Options.Nth and field-scoped
+// terms select from, so that they are not split again by every search with
+// the same delimiter.
+func newCorpus(withNth []Range, delimiter Delimiter, headerLines int, byFields bool) *Corpus {
+	corpus := &Corpus{}
+	// itemIndex and numHeader are only accessed by the itemBuilder which is
+	// called while the chunkList is locked
+	var itemIndex int32
+	numHeader := 0
+	corpus.chunkList = newChunkList(func(item *item, data []byte) bool {
+		if len(withNth) == 0 {
+			item.text = util.ToChars(data)
+		} else {
+			chars := util.ToChars(data)
+			tokens := transform(&chars, tokenize(&chars, delimiter), withNth)
+			item.text = util.ToChars([]byte(joinTokens(&chars, tokens, delimiter)))
+			item.text.TrimTrailingWhitespaces()
+		}
+		// The text of invalid UTF-8 is not the data as it was given
+		item.origText = &data
+		item.text.Index = itemIndex
+		itemIndex++
+
+		if numHeader < headerLines {
+			numHeader++
+			corpus.addHeader(item, delimiter)
+			return false
+		}
+		// Calculate the trim length in advance so that the item is never
+		// modified while it is being searched
+		item.text.TrimLength()
+		if byFields {
+			tokens := tokenize(&item.text, delimiter)
+			item.tokens = &tokens
+			item.delimiter = &delimiter
+		}
+		return true
+	})
+	return corpus
+}
+
+// addHeader adds the item to the header. The first header line names the
+// fields of the items.
+func (c *Corpus) addHeader(item *item, delimiter Delimiter) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if len(c.header) == 0 {
+		c.fields = fieldNames(&item.text, delimiter)
+	}
+	c.header = append(c.header, item.AsBytes())
+}
+
+// Push adds the item to the corpus
+func (c *Corpus) Push(data []byte) {
+	c.chunkList.Push(data)
+}
+
+// Len returns the number of items in the corpus excluding the header
+func (c *Corpus) Len() int {
+	_, count := c.chunkList.Snapshot()
+	return count
+}
+
+// Header returns the items that have been held aside as the header, as
+// transformed by Options.WithNth. They are never matched, but their indexes
+// are counted in Match.Index.
+func (c *Corpus) Header() [][]byte {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return append([][]byte{}, c.header...)
+}
+
+// fieldNames returns the names of the fields given by the first header line
+func (c *Corpus) fieldNames() []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.fields
+}
+
+// snapshot returns the chunks of the corpus at this point in time
+func (c *Corpus) snapshot() []*chunk {
+	chunks, _ := c.chunkList.Snapshot()
+	return chunks
+}
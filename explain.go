@@ -0,0 +1,189 @@
+package fzflib
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/bookreport/fzflib/algo"
+	"github.com/bookreport/fzflib/util"
+)
+
+// Explanation is the breakdown of the score and the rank of an item for a
+// query, see Searcher.Explain
+type Explanation struct {
+	// Score is the score of the match that the item is ranked with, the sum
+	// of the scores of the terms
+	Score int
+
+	// Terms are the terms that matched the item in the order of the query.
+	// Inverse terms and the alternatives of the matched terms are left out.
+	Terms []TermScore
+
+	// Points are the values that the sort criteria of Options.Tiebreak give
+	// to the item in order of precedence, as found in Match.Points. ByIndex
+	// and the criteria following it are left out.
+	Points []CriterionPoints
+}
+
+// TermScore is the breakdown of the score of a term
+type TermScore struct {
+	// Term is the term as it was matched. Its text is in lowercase if the
+	// term is case-insensitive.
+	Term Term
+
+	// Score is the score given by the algorithm of the term, the sum of the
+	// parts of Chars and of Residual
+	Score int
+
+	// Residual is the part of Score that does not come from the matched
+	// characters, such as the penalty of the typos of FuzzyMatchTypos or the
+	// score of EqualMatch that is computed on its own. The parts of the
+	// positions of FuzzyMatchV2 can also add up to a few points more or less
+	// than its score, see algo.Scheme.ExplainScore.
+	Residual int
+
+	// Offset is the [begin, end) rune offset of the substring matched by the
+	// term
+	Offset [2]int32
+
+	// Chars are the parts of the score that come from each matched character,
+	// see algo.Scheme.ExplainScore
+	Chars []algo.CharScore
+}
+
+// CriterionPoints is the value of a sort criterion for an item. Lower is
+// better.
+type CriterionPoints struct {
+	Criterion Criterion
+	Points    uint16
+}
+
+// String returns a human-readable description of the explanation, with a
+// line for each matched character
+func (e *Explanation) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "score: %d\n", e.Score)
+	for _, term := range e.Terms {
+		fmt.Fprintf(&sb, "%s: %d\n", term.Term.String(), term.Score)
+		for _, c := range term.Chars {
+			fmt.Fprintf(&sb, "  %3d %q: match %d, bonus %d, consecutive %d, gap %d\n",
+				c.Index, c.Char, c.Match, c.Bonus, c.Consecutive, c.Gap)
+		}
+		if term.Residual != 0 {
+			fmt.Fprintf(&sb, "  residual %d\n", term.Residual)
+		}
+	}
+	points := make([]string, len(e.Points))
+	for idx, p := range e.Points {
+		points[idx] = fmt.Sprintf("%s %d", p.Criterion, p.Points)
+	}
+	fmt.Fprintf(&sb, "points: %s\n", strings.Join(points, ", "))
+	return sb.String()
+}
+
+// Explain returns the breakdown of the score and the rank of the item for the
+// query. The item is transformed with Options.WithNth as if it was pushed to a
+// corpus created with NewCorpus. As the item has no header, the fields of
+// scoped terms cannot be named after its columns. It returns nil if the item
+// does not match the query, and a ParseError for a malformed query.
+func (s *Searcher) Explain(query string, data []byte) (*Explanation, error) {
+	pattern, err := s.buildPattern(context.Background(), query, nil)
+	if err != nil {
+		return nil, err
+	}
+	corpus := newCorpus(s.opts.WithNth, s.opts.Delimiter, 0, false)
+	corpus.Push(data)
+	item := &corpus.snapshot()[0].items[0]
+
+	slab := s.getSlab()
+	defer s.putSlab(slab)
+	result, _, _, score := pattern.scoreItem(item, false, slab)
+	if result == nil {
+		return nil, nil
+	}
+
+	explanation := &Explanation{Score: score, Terms: pattern.explainTerms(item, slab)}
+	for idx, criterion := range pattern.sortCriteria {
+		if criterion == ByIndex {
+			break
+		}
+		explanation.Points = append(explanation.Points, CriterionPoints{criterion, result.points[3-idx]})
+	}
+	return explanation, nil
+}
+
+// explainTerms returns the breakdown of the scores of the terms that match
+// the item. It mirrors extendedMatch, which picks the first term of each set
+// that matches.
+func (p *pattern) explainTerms(item *item, slab *util.Slab) []TermScore {
+	var input []token
+	if len(p.nth) == 0 {
+		input = []token{token{text: &item.text, prefixLength: 0}}
+	} else {
+		input = p.transformInput(item)
+	}
+	if !p.extended {
+		t := Term{Kind: TermExact, Text: string(p.text), CaseSensitive: p.caseSensitive}
+		if p.fuzzy {
+			t.Kind = TermFuzzy
+		}
+		if score, ok := p.explainTerm(t, p.procFun[t.Kind], input, []rune(t.Text), slab); ok {
+			return []TermScore{score}
+		}
+		return nil
+	}
+
+	var tokens []token
+	scores := []TermScore{}
+	for _, termSet := range p.termSets {
+		for _, term := range termSet {
+			if term.inv {
+				continue
+			}
+			termInput := input
+			if len(term.nth) > 0 {
+				if tokens == nil {
+					tokens = p.tokenize(item)
+				}
+				termInput = transform(&item.text, tokens, term.nth)
+			}
+			t := Term{Kind: term.typ, Text: string(term.text), CaseSensitive: term.caseSensitive, Nth: term.nth}
+			if score, ok := p.explainTerm(t, term.algo, termInput, term.text, slab); ok {
+				scores = append(scores, score)
+				break
+			}
+		}
+	}
+	return scores
+}
+
+// explainTerm matches the term against the tokens like iter, and breaks down
+// the score of the first match relative to the token it was found in
+func (p *pattern) explainTerm(t Term, pfun algo.Algo, tokens []token, text []rune, slab *util.Slab) (TermScore, bool) {
+	for _, part := range tokens {
+		res, pos := pfun(t.CaseSensitive, p.normalize, p.forward, part.text, text, true, slab)
+		if res.Start < 0 {
+			continue
+		}
+		var positions []int
+		if pos != nil {
+			positions = append(positions, *pos...)
+			sort.Ints(positions)
+		} else {
+			for idx := res.Start; idx < res.End; idx++ {
+				positions = append(positions, idx)
+			}
+		}
+		residual := res.Score
+		chars := p.scheme.ExplainScore(part.text, positions)
+		for idx := range chars {
+			chars[idx].Index += int(part.prefixLength)
+			residual -= chars[idx].Total()
+		}
+		offset := [2]int32{int32(res.Start) + part.prefixLength, int32(res.End) + part.prefixLength}
+		return TermScore{Term: t, Score: res.Score, Residual: residual, Offset: offset, Chars: chars}, true
+	}
+	return TermScore{}, false
+}
@@ -0,0 +1,36 @@
+package fzflib
+
+// SearcherOf searches lists of arbitrary values by the text extracted from
+// each value by its key function
+type SearcherOf[T any] struct {
+	searcher *Searcher
+	key      func(T) string
+}
+
+// MatchOf is a Match that carries the value it was extracted from
+type MatchOf[T any] struct {
+	Match
+	Value T
+}
+
+// NewSearcherOf returns a new SearcherOf configured with the given options.
+// The key function returns the text of a value to match the queries against.
+func NewSearcherOf[T any](opts Options, key func(T) string) *SearcherOf[T] {
+	return &SearcherOf[T]{searcher: NewSearcher(opts), key: key}
+}
+
+// Match returns the matches for the query among the values, ordered by
+// relevance
+func (s *SearcherOf[T]) Match(query string, values []T) []MatchOf[T] {
+	content := make([][]byte, len(values))
+	for idx, value := range values {
+		content[idx] = []byte(s.key(value))
+	}
+
+	matches := s.searcher.Match(query, content)
+	typed := make([]MatchOf[T], len(matches))
+	for idx, match := range matches {
+		typed[idx] = MatchOf[T]{Match: match, Value: values[match.Index]}
+	}
+	return typed
+}
@@ -6,9 +6,10 @@ import (
 
 // item represents each input line. 56 bytes.
 type item struct {
-	text        util.Chars // 32 = 24 + 1 + 1 + 2 + 4
-	transformed *[]token   // 8
-	origText    *[]byte    // 8
+	text      util.Chars // 32 = 24 + 1 + 1 + 2 + 4
+	tokens    *[]token   // 8
+	delimiter *Delimiter // 8
+	origText  *[]byte    // 8
 }
 
 // Index returns ordinal index of the item
@@ -22,7 +23,24 @@ func (item *item) TrimLength() uint16 {
 	return item.text.TrimLength()
 }
 
-// AsString returns the original string
+// AsString returns the string that is searched and displayed
 func (item *item) AsString() string {
 	return item.text.ToString()
 }
+
+// AsBytes returns the bytes that are searched and displayed
+func (item *item) AsBytes() []byte {
+	if item.text.IsBytes() {
+		return item.text.Bytes()
+	}
+	return []byte(item.text.ToString())
+}
+
+// OrigBytes returns the bytes of the item as they were given, before they
+// were decoded and transformed
+func (item *item) OrigBytes() []byte {
+	if item.origText != nil {
+		return *item.origText
+	}
+	return item.AsBytes()
+}
@@ -1,22 +1,18 @@
 package fzflib
 
 import (
-	"fmt"
-	"log"
+	"context"
+	"log/slog"
 )
 
-func logResult(r *result) {
-	log.Println(fmt.Sprintf("{ item: { text: '%v', origText: '%v' }, points: %v }", r.item.text, r.item.origText, r.points))
+// logEnabled returns true if the debug events of the Searcher are logged.
+// The events are only built when it returns true so that they cost nothing
+// otherwise.
+func (s *Searcher) logEnabled(ctx context.Context) bool {
+	return s.opts.Logger != nil && s.opts.Logger.Enabled(ctx, slog.LevelDebug)
 }
 
-func logResultSlice(results []result) {
-	l := "[\n"
-	log.Println("len(results)", len(results))
-	for _, r := range results {
-		l += fmt.Sprintf("{ item: { text: '%v' }, points: %v }", r.item.text.ToString(), r.points)
-		l += ",\n"
-	}
-
-	l += "\n]"
-	log.Println(l)
+// logDebug logs a debug event with structured fields
+func (s *Searcher) logDebug(ctx context.Context, msg string, attrs ...slog.Attr) {
+	s.opts.Logger.LogAttrs(ctx, slog.LevelDebug, msg, attrs...)
 }
@@ -0,0 +1,71 @@
+package fzflib
+
+import (
+	"sort"
+
+	"github.com/bookreport/fzflib/util"
+)
+
+// Match is an item that matched the query
+type Match struct {
+	// Index is the ordinal index of the item in the input
+	Index int
+
+	// Text is the content of the item that was searched. It is the item
+	// transformed with Options.WithNth if set, and the offsets and the
+	// positions refer to it.
+	Text []byte
+
+	// Original is the content of the item as it was given
+	Original []byte
+
+	// Score is the raw score computed by the matching algorithms. Higher is
+	// better.
+	Score int
+
+	// Points holds the value of each tiebreak criterion with the first
+	// criterion in the last element. Lower is better.
+	Points [4]uint16
+
+	// Offsets are the [begin, end) rune offsets of the substrings matched by
+	// each term of the query
+	Offsets [][2]int32
+
+	// Positions are the sorted rune indexes of the matched characters
+	Positions []int
+}
+
+// buildMatch matches the item again with positions enabled to build a Match
+// from a result. This is only done for the results that are returned to the
+// caller as computing the positions is considerably more expensive.
+func buildMatch(p *pattern, r result, slab *util.Slab) Match {
+	_, offsets, pos, score := p.scoreItem(r.item, true, slab)
+	match := Match{
+		Index:    int(r.Index()),
+		Text:     r.item.AsBytes(),
+		Original: r.item.OrigBytes(),
+		Score:    score,
+		Points:   r.points,
+		Offsets:  make([][2]int32, len(offsets))}
+	for idx, offset := range offsets {
+		match.Offsets[idx] = offset
+	}
+
+	var positions []int
+	if pos != nil {
+		positions = *pos
+	} else {
+		for _, offset := range offsets {
+			for idx := offset[0]; idx < offset[1]; idx++ {
+				positions = append(positions, int(idx))
+			}
+		}
+	}
+	sort.Ints(positions)
+	for idx, p := range positions {
+		if idx == 0 || p != positions[idx-1] {
+			match.Positions = append(match.Positions, p)
+		}
+	}
+	return match
+}
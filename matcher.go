@@ -0,0 +1,141 @@
+package fzflib
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// partialResult holds the sorted matches of a slice of chunks
+type partialResult struct {
+	index   int
+	matches []result
+	stats   Stats
+}
+
+// sliceChunks splits the chunks into at most the given number of partitions
+func sliceChunks(chunks []*chunk, partitions int) [][]*chunk {
+	perSlice := len(chunks) / partitions
+	if perSlice == 0 {
+		partitions = len(chunks)
+		perSlice = 1
+	}
+
+	slices := make([][]*chunk, partitions)
+	for i := 0; i < partitions; i++ {
+		start := i * perSlice
+		end := start + perSlice
+		if i == partitions-1 {
+			end = len(chunks)
+		}
+		slices[i] = chunks[start:end]
+	}
+	return slices
+}
+
+// scan matches the pattern against the chunks in parallel. Each partition is
+// sorted by its own goroutine, unless sorting is disabled, and the results are
+// merged by the returned merger, along with the stats of the scan. The context
+// is checked between chunks; if it is done, the merger only holds the matches
+// from the chunks scanned so far and the third return value is true.
+func (s *Searcher) scan(ctx context.Context, pattern *pattern, chunks []*chunk, cache *chunkCache) (*merger, Stats, bool) {
+	// We should not sort the result if there are only inverse search terms
+	sorted := !s.opts.NoSort && pattern.sortable
+	tac := s.opts.Tac
+	if len(chunks) == 0 {
+		return newMerger(nil, sorted, tac), Stats{}, false
+	}
+
+	slices := sliceChunks(chunks, s.partitions)
+	numSlices := len(slices)
+	resultChan := make(chan partialResult, numSlices)
+	waitGroup := sync.WaitGroup{}
+	var interrupted atomic.Bool
+	logging := s.logEnabled(ctx)
+	first := 0
+	for idx, chunks := range slices {
+		waitGroup.Add(1)
+		go func(idx int, first int, chunks []*chunk) {
+			defer waitGroup.Done()
+			slab := s.getSlab()
+			defer s.putSlab(slab)
+
+			// Only keep the best matches of the slice when the number of results
+			// is limited
+			var top *topResults
+			if sorted && s.opts.Limit > 0 {
+				top = newTopResults(s.opts.Limit, tac)
+			}
+
+			var stats Stats
+			count := 0
+			allMatches := make([][]result, len(chunks))
+			for idx, chunk := range chunks {
+				if interrupted.Load() {
+					break
+				}
+				if ctx.Err() != nil {
+					interrupted.Store(true)
+					break
+				}
+				start := time.Now()
+				matches, scan := pattern.Match(chunk, cache, slab)
+				duration := time.Since(start)
+				stats.addChunk(chunk.count, scan)
+				stats.Matches += len(matches)
+				stats.MatchTime += duration
+				if logging {
+					s.logDebug(ctx, "fzflib: chunk matched",
+						slog.Int("chunk", first+idx),
+						slog.Int("items", chunk.count),
+						slog.Int("scanned", scan.scanned),
+						slog.Int("matches", len(matches)),
+						slog.String("cache", scan.cache.String()),
+						slog.Duration("duration", duration))
+				}
+				if top != nil {
+					start = time.Now()
+					top.Add(matches)
+					stats.SortTime += time.Since(start)
+					continue
+				}
+				allMatches[idx] = matches
+				count += len(matches)
+			}
+
+			var sliceMatches []result
+			if top != nil {
+				sliceMatches = top.list
+			} else {
+				sliceMatches = make([]result, 0, count)
+				for _, matches := range allMatches {
+					sliceMatches = append(sliceMatches, matches...)
+				}
+			}
+			if sorted {
+				start := time.Now()
+				if tac {
+					sort.Sort(byRelevanceTac(sliceMatches))
+				} else {
+					sort.Sort(byRelevance(sliceMatches))
+				}
+				stats.SortTime += time.Since(start)
+			}
+			resultChan <- partialResult{idx, sliceMatches, stats}
+		}(idx, first, chunks)
+		first += len(chunks)
+	}
+	waitGroup.Wait()
+	close(resultChan)
+
+	var stats Stats
+	partialResults := make([][]result, numSlices)
+	for partialResult := range resultChan {
+		partialResults[partialResult.index] = partialResult.matches
+		stats.add(partialResult.stats)
+	}
+	return newMerger(partialResults, sorted, tac), stats, interrupted.Load()
+}
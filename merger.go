@@ -0,0 +1,81 @@
+package fzflib
+
+import "fmt"
+
+// merger holds a set of locally sorted lists of items and provides the view of
+// a single, globally-sorted list
+type merger struct {
+	lists   [][]result
+	merged  []result
+	cursors []int
+	sorted  bool
+	tac     bool
+	count   int
+}
+
+// newMerger returns a new merger object
+func newMerger(lists [][]result, sorted bool, tac bool) *merger {
+	mg := merger{
+		lists:   lists,
+		merged:  []result{},
+		cursors: make([]int, len(lists)),
+		sorted:  sorted,
+		tac:     tac,
+		count:   0}
+
+	for _, list := range mg.lists {
+		mg.count += len(list)
+	}
+	return &mg
+}
+
+// Length returns the number of items
+func (mg *merger) Length() int {
+	return mg.count
+}
+
+// Get returns the result at the given index
+func (mg *merger) Get(idx int) result {
+	if mg.sorted {
+		return mg.mergedGet(idx)
+	}
+
+	if mg.tac {
+		idx = mg.count - idx - 1
+	}
+	for _, list := range mg.lists {
+		numItems := len(list)
+		if idx < numItems {
+			return list[idx]
+		}
+		idx -= numItems
+	}
+	panic(fmt.Sprintf("Index out of bounds (unsorted, %d/%d)", idx, mg.count))
+}
+
+func (mg *merger) mergedGet(idx int) result {
+	for i := len(mg.merged); i <= idx; i++ {
+		minRank := minRank()
+		minIdx := -1
+		for listIdx, list := range mg.lists {
+			cursor := mg.cursors[listIdx]
+			if cursor < 0 || cursor == len(list) {
+				mg.cursors[listIdx] = -1
+				continue
+			}
+			rank := list[cursor]
+			if minIdx < 0 || compareRanks(rank, minRank, mg.tac) {
+				minRank = rank
+				minIdx = listIdx
+			}
+		}
+
+		if minIdx < 0 {
+			panic(fmt.Sprintf("Index out of bounds (sorted, %d/%d)", i, mg.count))
+		}
+		chosen := mg.lists[minIdx]
+		mg.merged = append(mg.merged, chosen[mg.cursors[minIdx]])
+		mg.cursors[minIdx]++
+	}
+	return mg.merged[idx]
+}
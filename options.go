@@ -0,0 +1,123 @@
+package fzflib
+
+import (
+	"log/slog"
+
+	"github.com/bookreport/fzflib/algo"
+)
+
+// Options holds the settings that control how a Searcher matches and ranks
+// the items
+type Options struct {
+	// Fuzzy enables fuzzy matching. Terms are matched exactly when false.
+	Fuzzy bool
+
+	// FuzzyAlgo is the algorithm used for fuzzy terms, e.g. algo.FuzzyMatchV1,
+	// algo.FuzzyMatchV2 or algo.FuzzyMatchTypos. Defaults to
+	// algo.FuzzyMatchV2 when nil. FuzzyMatchV1 and FuzzyMatchV2 score with
+	// Scheme, the other algorithms with their own scheme.
+	FuzzyAlgo algo.Algo
+
+	// Algos replaces the algorithms of the given kinds of terms. FuzzyAlgo
+	// is used for TermFuzzy unless it is given here. The algorithms follow the
+	// same contract as the built-in ones, see algo.Algo.
+	Algos map[TermKind]algo.Algo
+
+	// CustomTerms defines additional kinds of terms for the extended-search
+	// syntax, see CustomTerm
+	CustomTerms []CustomTerm
+
+	// Extended enables the extended-search syntax ('exact, ^prefix, suffix$,
+	// !inverse and | for OR).
+	Extended bool
+
+	// Case determines the case-sensitivity of the search
+	Case CaseMode
+
+	// Normalize makes latin script letters match their unaccented forms
+	Normalize bool
+
+	// Forward scans the items from the beginning. Backward scanning prefers
+	// matches closer to the end of the items.
+	Forward bool
+
+	// Nth limits the search scope to the given fields of the items, see
+	// ParseNth. The items are split into fields once by the corpora created
+	// with Searcher.NewCorpus, and by every search otherwise.
+	Nth []Range
+
+	// WithNth transforms the items to the given fields before they are
+	// searched, see ParseNth. Nth and field-scoped terms then apply to the
+	// transformed items. It is applied by Match, Search and the corpora
+	// created with Searcher.NewCorpus when the items are pushed.
+	WithNth []Range
+
+	// FieldScopes enables the terms that are limited to some fields of the
+	// items with a prefix in the syntax of ParseNth, e.g. "2:foo" or
+	// "-1:^bar". It is disabled by default as "12:30" would then search the
+	// 12th field for "30".
+	FieldScopes bool
+
+	// HeaderLines is the number of the first items that are held aside as
+	// the header instead of being searched, see Corpus.Header. The fields of
+	// the first header line can be used by name as field scopes in the
+	// queries if FieldScopes is set, e.g. "name:foo". It is applied by Match,
+	// Search and the corpora created with Searcher.NewCorpus.
+	HeaderLines int
+
+	// Delimiter is used to split the items into fields for WithNth and Nth,
+	// see ParseDelimiter and CSVDelimiter. The items are split AWK-style when
+	// it is the zero value.
+	Delimiter Delimiter
+
+	// Scheme is the scoring scheme of the built-in algorithms, see
+	// algo.ParseScheme. Defaults to algo.DefaultScheme when nil. The matches in
+	// the last component of a path rank higher with algo.PathScheme, which
+	// adds ByPathname after ByScore to Tiebreak unless it is already there or
+	// Tiebreak already has four criteria besides ByIndex.
+	// ByLength makes less of a difference with algo.HistoryScheme.
+	Scheme *algo.Scheme
+
+	// Tiebreak is the list of sort criteria in order of precedence, see
+	// ParseTiebreak. At most four criteria are used. Items that tie on all of
+	// the criteria are ranked by their index.
+	Tiebreak []Criterion
+
+	// NoSort disables sorting the matches by relevance. They are returned in
+	// input order instead.
+	NoSort bool
+
+	// Tac reverses the input order, both for ranking the items that tie and
+	// for the order of the matches when NoSort is set
+	Tac bool
+
+	// Limit is the maximum number of matches to return. Only the best matches
+	// are kept while searching instead of sorting all of them. There is no
+	// limit if it is zero.
+	Limit int
+
+	// PartialResults makes an interrupted search return the matches found so
+	// far along with the error
+	PartialResults bool
+
+	// Logger receives the debug events of the Searcher, such as the building
+	// of the patterns, the use of the cache and the time spent on each chunk.
+	// Nothing is logged when it is nil. See algo.Scheme.WithLogger for the
+	// internals of the algorithm.
+	Logger *slog.Logger
+}
+
+// DefaultOptions returns the options used by the package-level Search
+func DefaultOptions() Options {
+	return Options{
+		Fuzzy:     true,
+		FuzzyAlgo: algo.FuzzyMatchV2,
+		Extended:  true,
+		Case:      CaseSmart,
+		Normalize: true,
+		Forward:   true,
+		Nth:       make([]Range, 0),
+		Delimiter: Delimiter{},
+		Scheme:    algo.DefaultScheme,
+		Tiebreak:  []Criterion{ByScore, ByLength}}
+}
@@ -2,6 +2,7 @@ package fzflib
 
 import (
 	"fmt"
+	"reflect"
 	"regexp"
 	"strings"
 
@@ -17,35 +18,99 @@ import (
 // !'inverse-fuzzy
 // !^inverse-prefix-exact
 // !inverse-suffix-exact$
+// /regex/
+// !/inverse-regex/
+// 2:field-scoped
+// -1:^field-scoped-prefix-exact
 
-type termType int
+// TermKind is the match type of a search term
+type TermKind int
 
+// Match types
 const (
-	termFuzzy termType = iota
-	termExact
-	termPrefix
-	termSuffix
-	termEqual
+	TermFuzzy TermKind = iota
+	TermExact
+	TermPrefix
+	TermSuffix
+	TermEqual
+	TermRegex
+
+	// TermCustom is the kind of the first term of Options.CustomTerms, the
+	// following ones have the next kinds
+	TermCustom
 )
 
+// CustomTerm is a kind of term written with a prefix, e.g. "v:" for terms
+// like "v:1.2", and matched with a custom algorithm. The prefix comes after
+// the "!" of an inverse term. Terms that start with the prefix of a custom
+// term are not parsed further.
+type CustomTerm struct {
+	Prefix string
+	Algo   algo.Algo
+}
+
+// defaultAlgos returns the built-in algorithms of each kind of term scoring
+// with the scheme. FuzzyMatchV1 and FuzzyMatchV2 are replaced by their
+// counterparts of the scheme, any other fuzzy algorithm is used as is.
+func defaultAlgos(fuzzyAlgo algo.Algo, scheme *algo.Scheme) map[TermKind]algo.Algo {
+	switch reflect.ValueOf(fuzzyAlgo).Pointer() {
+	case reflect.ValueOf(algo.FuzzyMatchV1).Pointer():
+		fuzzyAlgo = scheme.FuzzyMatchV1
+	case reflect.ValueOf(algo.FuzzyMatchV2).Pointer():
+		fuzzyAlgo = scheme.FuzzyMatchV2
+	}
+	return map[TermKind]algo.Algo{
+		TermFuzzy:  fuzzyAlgo,
+		TermEqual:  scheme.EqualMatch,
+		TermExact:  scheme.ExactMatchNaive,
+		TermPrefix: scheme.PrefixMatch,
+		TermSuffix: scheme.SuffixMatch,
+		TermRegex:  scheme.RegexMatch}
+}
+
 type term struct {
-	typ           termType
+	typ           TermKind
 	inv           bool
 	text          []rune
 	caseSensitive bool
+	nth           []Range
+
+	// algo is the algorithm of the term, which matches the compiled
+	// expression of a regex term
+	algo algo.Algo
 }
 
 // String returns the string representation of a term.
 func (t term) String() string {
-	return fmt.Sprintf("term{typ: %d, inv: %v, text: []rune(%q), caseSensitive: %v}", t.typ, t.inv, string(t.text), t.caseSensitive)
+	return fmt.Sprintf("term{typ: %d, inv: %v, text: []rune(%q), caseSensitive: %v, nth: %v}", t.typ, t.inv, string(t.text), t.caseSensitive, t.nth)
 }
 
 type termSet []term
 
+// ParseError describes a malformed term in a query
+type ParseError struct {
+	// Term is the malformed term
+	Term string
+
+	// Span is the location of the term in the query
+	Span Span
+
+	Err error
+}
+
+// Error returns the description of the error
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("invalid term %q: %v", e.Term, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
 // pattern represents search pattern
 type pattern struct {
 	fuzzy         bool
-	fuzzyAlgo     algo.Algo
 	extended      bool
 	caseSensitive bool
 	normalize     bool
@@ -55,49 +120,46 @@ type pattern struct {
 	sortable      bool
 	cacheable     bool
 	cacheKey      string
-	delimiter     inputDelimiter
-	nth           []exprRange
-	procFun       map[termType]algo.Algo
-}
-
-var (
-	_patternCache map[string]*pattern
-	_splitRegex   *regexp.Regexp
-	_cache        chunkCache
-)
-
-func init() {
-	_splitRegex = regexp.MustCompile(" +")
-	clearPatternCache()
-	clearChunkCache()
+	delimiter     Delimiter
+	nth           []Range
+	sortCriteria  []Criterion
+	scheme        *algo.Scheme
+	procFun       map[TermKind]algo.Algo
+	customAlgo    map[TermKind]bool
 }
 
-func clearPatternCache() {
-	// We can uniquely identify the pattern for a given string since
-	// search mode and caseMode do not change while the program is running
-	_patternCache = make(map[string]*pattern)
-}
-
-func clearChunkCache() {
-	_cache = newChunkCache()
-}
-
-// buildPattern builds pattern object from the given arguments
+// buildPattern builds pattern object from the given arguments. The algos
+// replace the built-in algorithms of their kinds of terms, which score with
+// the scheme. The runes are
+// ignored if the parsed query is given. Field scopes are only parsed in the
+// runes if fieldScopes is set, and the field names are the ones that can be
+// used as such. It returns a ParseError if the query
+// contains a malformed term.
 func buildPattern(
 	fuzzy bool,
 	fuzzyAlgo algo.Algo,
+	algos map[TermKind]algo.Algo,
+	customTerms []CustomTerm,
 	extended bool,
-	caseMode searchCase,
+	caseMode CaseMode,
 	normalize bool,
 	forward bool,
 	cacheable bool,
-	nth []exprRange,
-	delimiter inputDelimiter,
+	nth []Range,
+	delimiter Delimiter,
+	sortCriteria []Criterion,
+	scheme *algo.Scheme,
+	fieldScopes bool,
+	fields []string,
+	query *Query,
 	runes []rune,
-) *pattern {
+) (*pattern, error) {
 
 	var asString string
-	if extended {
+	if query != nil {
+		// The query has already been parsed
+		asString = query.String()
+	} else if extended {
 		asString = strings.TrimLeft(string(runes), " ")
 		for strings.HasSuffix(asString, " ") && !strings.HasSuffix(asString, "\\ ") {
 			asString = asString[:len(asString)-1]
@@ -106,17 +168,39 @@ func buildPattern(
 		asString = string(runes)
 	}
 
-	cached, found := _patternCache[asString]
-	if found {
-		return cached
+	procFun := defaultAlgos(fuzzyAlgo, scheme)
+	customAlgo := make(map[TermKind]bool)
+	if !isBuiltinFuzzy(fuzzyAlgo) {
+		customAlgo[TermFuzzy] = true
+	}
+	for kind, pfun := range algos {
+		procFun[kind] = pfun
+		customAlgo[kind] = true
+	}
+	for idx, custom := range customTerms {
+		procFun[TermCustom+TermKind(idx)] = custom.Algo
+		customAlgo[TermCustom+TermKind(idx)] = true
 	}
 
 	caseSensitive := true
 	sortable := true
 	termSets := []termSet{}
 
-	if extended {
-		termSets = parseTerms(fuzzy, caseMode, normalize, asString)
+	if query != nil || extended {
+		extended = true
+		var err error
+		if query == nil {
+			if query, err = parseQuery(asString, fuzzy, caseMode, fieldScopes, fields, customTerms, false); err != nil {
+				return nil, err
+			}
+		}
+		var regexAlgo func(*regexp.Regexp) algo.Algo
+		if !customAlgo[TermRegex] {
+			regexAlgo = scheme.RegexpMatch
+		}
+		if termSets, err = query.termSets(normalize, procFun, regexAlgo); err != nil {
+			return nil, err
+		}
 		// We should not sort the result if there are only inverse search terms
 		sortable = false
 	Loop:
@@ -127,7 +211,7 @@ func buildPattern(
 				}
 				// If the query contains inverse search terms or OR operators,
 				// we cannot cache the search scope
-				if !cacheable || idx > 0 || term.inv || fuzzy && term.typ != termFuzzy || !fuzzy && term.typ != termExact {
+				if !cacheable || idx > 0 || term.inv || len(term.nth) > 0 || customAlgo[term.typ] || fuzzy && term.typ != TermFuzzy || !fuzzy && term.typ != TermExact {
 					cacheable = false
 					if sortable {
 						// Can't break until we see at least one non-inverse term
@@ -137,9 +221,12 @@ func buildPattern(
 			}
 		}
 	} else {
+		if fuzzy && customAlgo[TermFuzzy] || !fuzzy && customAlgo[TermExact] {
+			cacheable = false
+		}
 		lowerString := strings.ToLower(asString)
-		caseSensitive = caseMode == searchCaseRespect ||
-			caseMode == searchCaseSmart && lowerString != asString
+		caseSensitive = caseMode == CaseRespect ||
+			caseMode == CaseSmart && lowerString != asString
 		if !caseSensitive {
 			asString = lowerString
 		}
@@ -147,7 +234,6 @@ func buildPattern(
 
 	ptr := &pattern{
 		fuzzy:         fuzzy,
-		fuzzyAlgo:     fuzzyAlgo,
 		extended:      extended,
 		caseSensitive: caseSensitive,
 		normalize:     normalize,
@@ -158,95 +244,22 @@ func buildPattern(
 		cacheable:     cacheable,
 		nth:           nth,
 		delimiter:     delimiter,
-		procFun:       make(map[termType]algo.Algo)}
+		sortCriteria:  sortCriteria,
+		scheme:        scheme,
+		procFun:       procFun,
+		customAlgo:    customAlgo}
 
 	ptr.cacheKey = ptr.buildCacheKey()
-	ptr.procFun[termFuzzy] = fuzzyAlgo
-	ptr.procFun[termEqual] = algo.EqualMatch
-	ptr.procFun[termExact] = algo.ExactMatchNaive
-	ptr.procFun[termPrefix] = algo.PrefixMatch
-	ptr.procFun[termSuffix] = algo.SuffixMatch
-
-	_patternCache[asString] = ptr
-	return ptr
+	return ptr, nil
 }
 
-func parseTerms(fuzzy bool, caseMode searchCase, normalize bool, str string) []termSet {
-	str = strings.Replace(str, "\\ ", "\t", -1)
-	tokens := _splitRegex.Split(str, -1)
-	sets := []termSet{}
-	set := termSet{}
-	switchSet := false
-	afterBar := false
-	for _, token := range tokens {
-		typ, inv, text := termFuzzy, false, strings.Replace(token, "\t", " ", -1)
-		lowerText := strings.ToLower(text)
-		caseSensitive := caseMode == searchCaseRespect ||
-			caseMode == searchCaseSmart && text != lowerText
-		if !caseSensitive {
-			text = lowerText
-		}
-		if !fuzzy {
-			typ = termExact
-		}
-
-		if len(set) > 0 && !afterBar && text == "|" {
-			switchSet = false
-			afterBar = true
-			continue
-		}
-		afterBar = false
-
-		if strings.HasPrefix(text, "!") {
-			inv = true
-			typ = termExact
-			text = text[1:]
-		}
-
-		if text != "$" && strings.HasSuffix(text, "$") {
-			typ = termSuffix
-			text = text[:len(text)-1]
-		}
-
-		if strings.HasPrefix(text, "'") {
-			// Flip exactness
-			if fuzzy && !inv {
-				typ = termExact
-				text = text[1:]
-			} else {
-				typ = termFuzzy
-				text = text[1:]
-			}
-		} else if strings.HasPrefix(text, "^") {
-			if typ == termSuffix {
-				typ = termEqual
-			} else {
-				typ = termPrefix
-			}
-			text = text[1:]
-		}
-
-		if len(text) > 0 {
-			if switchSet {
-				sets = append(sets, set)
-				set = termSet{}
-			}
-			textRunes := []rune(text)
-			if normalize {
-				textRunes = algo.NormalizeRunes(textRunes)
-			}
-			set = append(set, term{
-				typ:           typ,
-				inv:           inv,
-				text:          textRunes,
-				caseSensitive: caseSensitive})
-			switchSet = true
-		}
-	}
-	if len(set) > 0 {
-		sets = append(sets, set)
-	}
-	return sets
+// isBuiltinFuzzy returns true if pfun is FuzzyMatchV1 or FuzzyMatchV2. The
+// chunk cache relies on their matches narrowing down as the pattern grows,
+// which other fuzzy algorithms such as FuzzyMatchTypos do not guarantee.
+func isBuiltinFuzzy(pfun algo.Algo) bool {
+	ptr := reflect.ValueOf(pfun).Pointer()
+	return ptr == reflect.ValueOf(algo.FuzzyMatchV1).Pointer() ||
+		ptr == reflect.ValueOf(algo.FuzzyMatchV2).Pointer()
 }
 
 // IsEmpty returns true if the pattern is effectively empty
@@ -264,12 +277,21 @@ func (p *pattern) AsString() string {
 
 func (p *pattern) buildCacheKey() string {
 	if !p.extended {
+		if p.fuzzy && p.customAlgo[TermFuzzy] || !p.fuzzy && p.customAlgo[TermExact] {
+			// The results of a custom algorithm cannot narrow down the search
+			// scope of the next queries
+			return ""
+		}
 		return p.AsString()
 	}
 	cacheableTerms := []string{}
 	for _, termSet := range p.termSets {
-		if len(termSet) == 1 && !termSet[0].inv && (p.fuzzy || termSet[0].typ == termExact) {
-			cacheableTerms = append(cacheableTerms, string(termSet[0].text))
+		// The results of a regular expression or a custom algorithm are not
+		// a subset of the fuzzy matches of its text, nor are those of a term
+		// scoped to fields other than the ones of the pattern
+		term := termSet[0]
+		if len(termSet) == 1 && !term.inv && term.typ != TermRegex && !p.customAlgo[term.typ] && len(term.nth) == 0 && (p.fuzzy || term.typ == TermExact) {
+			cacheableTerms = append(cacheableTerms, string(term.text))
 		}
 	}
 	return strings.Join(cacheableTerms, "\t")
@@ -280,25 +302,65 @@ func (p *pattern) CacheKey() string {
 	return p.cacheKey
 }
 
-// Match returns the list of matches Items in the given chunk
-func (p *pattern) Match(chunk *chunk, slab *util.Slab) []result {
+// cacheStatus tells how the chunk cache was used to match a chunk
+type cacheStatus int
+
+const (
+	cacheUnused cacheStatus = iota
+	cacheMiss
+	cacheHit
+	cacheNarrowed
+)
+
+// String returns the name of the status
+func (s cacheStatus) String() string {
+	switch s {
+	case cacheMiss:
+		return "miss"
+	case cacheHit:
+		return "hit"
+	case cacheNarrowed:
+		return "narrowed"
+	}
+	return "unused"
+}
+
+// chunkScan describes how the matches of a chunk were found
+type chunkScan struct {
+	cache cacheStatus
+
+	// scanned is the number of items that were matched against the pattern
+	scanned int
+}
+
+// Match returns the list of matches Items in the given chunk. The cache is
+// not used if it is nil.
+func (p *pattern) Match(chunk *chunk, cache *chunkCache, slab *util.Slab) ([]result, chunkScan) {
+	if cache == nil {
+		return p.matchChunk(chunk, nil, slab), chunkScan{cacheUnused, chunk.count}
+	}
+
 	// chunkCache: Exact match
 	cacheKey := p.CacheKey()
 	if p.cacheable {
-		if cached := _cache.Lookup(chunk, cacheKey); cached != nil {
-			return cached
+		if cached := cache.Lookup(chunk, cacheKey); cached != nil {
+			return cached, chunkScan{cacheHit, 0}
 		}
 	}
 
 	// Prefix/suffix cache
-	space := _cache.Search(chunk, cacheKey)
+	space := cache.Search(chunk, cacheKey)
 
 	matches := p.matchChunk(chunk, space, slab)
+	scan := chunkScan{cacheMiss, chunk.count}
+	if space != nil {
+		scan = chunkScan{cacheNarrowed, len(space)}
+	}
 
 	if p.cacheable {
-		_cache.Add(chunk, cacheKey, matches)
+		cache.Add(chunk, cacheKey, matches)
 	}
-	return matches
+	return matches, scan
 }
 
 func (p *pattern) matchChunk(chunk *chunk, space []result, slab *util.Slab) []result {
@@ -322,20 +384,26 @@ func (p *pattern) matchChunk(chunk *chunk, space []result, slab *util.Slab) []re
 
 // MatchItem returns true if the item is a match
 func (p *pattern) MatchItem(item *item, withPos bool, slab *util.Slab) (*result, []substrOffset, *[]int) {
+	result, offsets, pos, _ := p.scoreItem(item, withPos, slab)
+	return result, offsets, pos
+}
+
+// scoreItem is MatchItem that also returns the raw score of the match
+func (p *pattern) scoreItem(item *item, withPos bool, slab *util.Slab) (*result, []substrOffset, *[]int, int) {
 	if p.extended {
 		if offsets, bonus, pos := p.extendedMatch(item, withPos, slab); len(offsets) == len(p.termSets) {
-			result := buildResult(item, offsets, bonus)
-			return &result, offsets, pos
+			result := buildResult(item, offsets, bonus, p.sortCriteria, p.scheme)
+			return &result, offsets, pos, bonus
 		}
-		return nil, nil, nil
+		return nil, nil, nil, 0
 	}
 	offset, bonus, pos := p.basicMatch(item, withPos, slab)
 	if sidx := offset[0]; sidx >= 0 {
 		offsets := []substrOffset{offset}
-		result := buildResult(item, offsets, bonus)
-		return &result, offsets, pos
+		result := buildResult(item, offsets, bonus, p.sortCriteria, p.scheme)
+		return &result, offsets, pos, bonus
 	}
-	return nil, nil, nil
+	return nil, nil, nil, 0
 }
 
 func (p *pattern) basicMatch(item *item, withPos bool, slab *util.Slab) (substrOffset, int, *[]int) {
@@ -346,9 +414,9 @@ func (p *pattern) basicMatch(item *item, withPos bool, slab *util.Slab) (substrO
 		input = p.transformInput(item)
 	}
 	if p.fuzzy {
-		return p.iter(p.fuzzyAlgo, input, p.caseSensitive, p.normalize, p.forward, p.text, withPos, slab)
+		return p.iter(p.procFun[TermFuzzy], input, p.caseSensitive, p.normalize, p.forward, p.text, withPos, slab)
 	}
-	return p.iter(algo.ExactMatchNaive, input, p.caseSensitive, p.normalize, p.forward, p.text, withPos, slab)
+	return p.iter(p.procFun[TermExact], input, p.caseSensitive, p.normalize, p.forward, p.text, withPos, slab)
 }
 
 func (p *pattern) extendedMatch(item *item, withPos bool, slab *util.Slab) ([]substrOffset, int, *[]int) {
@@ -358,6 +426,8 @@ func (p *pattern) extendedMatch(item *item, withPos bool, slab *util.Slab) ([]su
 	} else {
 		input = p.transformInput(item)
 	}
+	// The tokens of the whole item are only computed for field-scoped terms
+	var tokens []token
 	offsets := []substrOffset{}
 	var totalScore int
 	var allPos *[]int
@@ -369,8 +439,14 @@ func (p *pattern) extendedMatch(item *item, withPos bool, slab *util.Slab) ([]su
 		var currentScore int
 		matched := false
 		for _, term := range termSet {
-			pfun := p.procFun[term.typ]
-			off, score, pos := p.iter(pfun, input, term.caseSensitive, p.normalize, p.forward, term.text, withPos, slab)
+			termInput := input
+			if len(term.nth) > 0 {
+				if tokens == nil {
+					tokens = p.tokenize(item)
+				}
+				termInput = transform(&item.text, tokens, term.nth)
+			}
+			off, score, pos := p.iter(term.algo, termInput, term.caseSensitive, p.normalize, p.forward, term.text, withPos, slab)
 			if sidx := off[0]; sidx >= 0 {
 				if term.inv {
 					continue
@@ -402,14 +478,19 @@ func (p *pattern) extendedMatch(item *item, withPos bool, slab *util.Slab) ([]su
 }
 
 func (p *pattern) transformInput(item *item) []token {
-	if item.transformed != nil {
-		return *item.transformed
-	}
+	// The result is not memoized on the item as the same item can be
+	// searched concurrently by Searchers with different nth expressions
+	return transform(&item.text, p.tokenize(item), p.nth)
+}
 
-	tokens := tokenize(item.text.ToString(), p.delimiter)
-	ret := transform(tokens, p.nth)
-	item.transformed = &ret
-	return ret
+// tokenize returns the fields of the item split with the delimiter. The
+// fields computed by the corpus are only used if they were split with the
+// same delimiter.
+func (p *pattern) tokenize(item *item) []token {
+	if item.tokens != nil && *item.delimiter == p.delimiter {
+		return *item.tokens
+	}
+	return tokenize(&item.text, p.delimiter)
 }
 
 func (p *pattern) iter(pfun algo.Algo, tokens []token, caseSensitive bool, normalize bool, forward bool, pattern []rune, withPos bool, slab *util.Slab) (substrOffset, int, *[]int) {
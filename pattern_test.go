@@ -0,0 +1,353 @@
+package fzflib
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"regexp/syntax"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/bookreport/fzflib/algo"
+	"github.com/bookreport/fzflib/util"
+)
+
+func TestRegexTerm(t *testing.T) {
+	content := [][]byte{
+		[]byte("release-1.20.3"),
+		[]byte("release-candidate"),
+		[]byte("Release-2.0.0"),
+		[]byte("café-1.0"),
+	}
+
+	searcher := NewSearcher(DefaultOptions())
+	matches, err := searcher.MatchCorpusContext(context.Background(), `/\d+\.\d+\.\d+/`, corpusOf(content))
+	if err != nil || len(matches) != 2 {
+		t.Fatalf("expected 2 matches, got %d (%v)", len(matches), err)
+	}
+	for _, match := range matches {
+		if match.Index == 0 && !reflect.DeepEqual(match.Positions, []int{8, 9, 10, 11, 12, 13}) {
+			t.Errorf("unexpected positions: %v", match.Positions)
+		}
+	}
+
+	// Smart-case applies to the expression
+	if result := searcher.Search("/^release-/", content); len(result) != 3 {
+		t.Errorf("expected 3 case-insensitive matches, got %q", result)
+	}
+	if result := searcher.Search("/^Release-/", content); len(result) != 1 {
+		t.Errorf("expected 1 case-sensitive match, got %q", result)
+	}
+	if result := searcher.Search(`!/\d/ cand`, content); len(result) != 1 || string(result[0]) != "release-candidate" {
+		t.Errorf("expected only 'release-candidate', got %q", result)
+	}
+	if result := searcher.Search("/cafe-1/", content); len(result) != 1 {
+		t.Errorf("expected a normalized match, got %q", result)
+	}
+	for _, query := range []string{"/café-1/", "/caf[éè]-1/"} {
+		if result := searcher.Search(query, content); len(result) != 1 {
+			t.Errorf("%s: expected a normalized match, got %q", query, result)
+		}
+	}
+	if result := searcher.Search("/Café-1/", [][]byte{[]byte("Café-1"), []byte("café-1")}); len(result) != 1 || string(result[0]) != "Café-1" {
+		t.Errorf("expected a case-sensitive normalized match, got %q", result)
+	}
+
+	// Escape sequences do not make the expression case-sensitive
+	meetings := [][]byte{[]byte("MEETING"), []byte("meeting room"), []byte("Ωmeeting")}
+	for query, expected := range map[string]int{`/\S+ing/`: 3, `/\bMEET/`: 1, `/\p{L}meet/`: 1} {
+		if result := searcher.Search(query, meetings); len(result) != expected {
+			t.Errorf("%s: expected %d matches, got %q", query, expected, result)
+		}
+	}
+
+	// The last match is found when scanning backward, both in ASCII and in
+	// Unicode text
+	opts := DefaultOptions()
+	opts.Forward = false
+	for data, expected := range map[string][]int{"release-1.20.3": {13}, "café-1.0": {7}} {
+		matches := NewSearcher(opts).Match(`/\d/`, [][]byte{[]byte(data)})
+		if len(matches) != 1 || !reflect.DeepEqual(matches[0].Positions, expected) {
+			t.Errorf("%s: expected positions %v, got %v", data, expected, matches)
+		}
+	}
+
+	_, err = searcher.MatchCorpusContext(context.Background(), "foo /[a-/", corpusOf(content))
+	var parseErr *ParseError
+	var syntaxErr *syntax.Error
+	if !errors.As(err, &parseErr) || parseErr.Term != "/[a-/" || !errors.As(err, &syntaxErr) {
+		t.Errorf("expected a ParseError for the invalid expression, got %v", err)
+	}
+	if result := searcher.Search("/[a-/", content); result != nil {
+		t.Errorf("expected no results for an invalid query, got %q", result)
+	}
+}
+
+func corpusOf(content [][]byte) *Corpus {
+	corpus := NewCorpus()
+	for _, data := range content {
+		corpus.Push(data)
+	}
+	return corpus
+}
+
+func TestParseNth(t *testing.T) {
+	for str, expected := range map[string][]Range{
+		"1":          {{1, 1}},
+		"1,3..5,-1":  {{1, 1}, {3, 5}, {-1, -1}},
+		"..-2":       {{rangeEllipsis, -2}},
+		"2..":        {{2, rangeEllipsis}},
+		"..":         {{rangeEllipsis, rangeEllipsis}},
+		"-3..-1,2,4": {{-3, -1}, {2, 2}, {4, 4}},
+	} {
+		if ranges, err := ParseNth(str); err != nil || !reflect.DeepEqual(ranges, expected) {
+			t.Errorf("%q: expected %v, got %v (%v)", str, expected, ranges, err)
+		}
+		if ranges, _ := ParseNth(str); formatNth(ranges) != str {
+			t.Errorf("%q: unexpected string representation: %s", str, formatNth(ranges))
+		}
+	}
+
+	for str, expected := range map[string]string{
+		"":       `invalid nth expression: ""`,
+		"1,,2":   `invalid nth expression: ""`,
+		"x":      `invalid nth expression: "x"`,
+		"1..2..": `invalid nth expression: "1..2.."`,
+		"+2":     `invalid nth expression: "+2"`,
+		"1..+3":  `invalid nth expression: "1..+3"`,
+		"0":      `invalid nth expression: "0": field indexes start at 1`,
+		"2,1..0": `invalid nth expression: "1..0": field indexes start at 1`,
+	} {
+		if _, err := ParseNth(str); err == nil || err.Error() != expected {
+			t.Errorf("%q: expected error %q, got %v", str, expected, err)
+		}
+	}
+}
+
+func TestParseDelimiter(t *testing.T) {
+	if delim := ParseDelimiter(","); delim.str == nil || *delim.str != "," || delim.regex != nil {
+		t.Errorf("expected a string delimiter, got %v", delim)
+	}
+	if delim := ParseDelimiter(`\t`); delim.str == nil || *delim.str != "\t" {
+		t.Errorf("expected a tab delimiter, got %v", delim)
+	}
+	if delim := ParseDelimiter("["); delim.str == nil || *delim.str != "[" {
+		t.Errorf("expected a string delimiter for an invalid expression, got %v", delim)
+	}
+	delim := ParseDelimiter("[:;]+")
+	if delim.regex == nil || delim.str != nil {
+		t.Fatalf("expected a regex delimiter, got %v", delim)
+	}
+	text := util.ToChars([]byte("a:b;;c"))
+	tokens := tokenize(&text, delim)
+	if len(tokens) != 3 || tokens[1].text.ToString() != "b;;" || tokens[2].prefixLength != 5 {
+		t.Errorf("unexpected tokens: %v", tokens)
+	}
+}
+
+// versionMatch matches the version numbers that start with the pattern, e.g.
+// "1.2" matches "1.2" and "1.2.3" but not "1.20"
+func versionMatch(caseSensitive bool, normalize bool, forward bool, text *util.Chars, pattern []rune, withPos bool, slab *util.Slab) (algo.Result, *[]int) {
+	isVersion := func(r rune) bool { return r == '.' || r >= '0' && r <= '9' }
+	runes := text.ToRunes()
+	for begin := 0; begin+len(pattern) <= len(runes); begin++ {
+		if begin > 0 && isVersion(runes[begin-1]) || string(runes[begin:begin+len(pattern)]) != string(pattern) {
+			continue
+		}
+		end := begin + len(pattern)
+		if end == len(runes) || runes[end] == '.' || !isVersion(runes[end]) {
+			return algo.Result{Start: begin, End: end, Score: len(pattern)}, nil
+		}
+	}
+	return algo.Result{Start: -1, End: -1, Score: 0}, nil
+}
+
+func TestCustomAlgos(t *testing.T) {
+	content := [][]byte{
+		[]byte("libfoo 1.2.3"),
+		[]byte("libfoo 1.20"),
+		[]byte("libbar 1.2"),
+		[]byte("libv:1.2"),
+	}
+	opts := DefaultOptions()
+	opts.CustomTerms = []CustomTerm{{Prefix: "v:", Algo: versionMatch}}
+	searcher := NewSearcher(opts)
+
+	matches, err := searcher.MatchCorpusContext(context.Background(), "v:1.2 !v:1.2.3 | bar", corpusOf(content))
+	if err != nil || len(matches) != 2 {
+		t.Fatalf("expected 2 matches, got %v (%v)", matches, err)
+	}
+	for _, match := range matches {
+		if match.Index == 2 && !reflect.DeepEqual(match.Positions, []int{3, 4, 5, 7, 8, 9}) {
+			t.Errorf("unexpected positions: %v", match.Positions)
+		}
+	}
+
+	query, err := ParseQuery("!v:1.2 'v:1.2", opts)
+	if err != nil || query.Groups[0].Terms[0].Kind != TermCustom || !query.Groups[0].Terms[0].Inverse ||
+		query.Groups[1].Terms[0].Kind != TermExact || query.Groups[1].Terms[0].Text != "v:1.2" {
+		t.Errorf("unexpected query: %+v (%v)", query, err)
+	}
+	if _, err := NewSearcher(DefaultOptions()).MatchQueryContext(context.Background(), query, corpusOf(content)); !errors.Is(err, errInvalidKind) {
+		t.Errorf("expected an error for an unknown kind, got %v", err)
+	}
+
+	// The algorithms of the built-in kinds can be replaced
+	opts = DefaultOptions()
+	opts.Algos = map[TermKind]algo.Algo{TermFuzzy: versionMatch}
+	searcher = NewSearcher(opts)
+	corpus := corpusOf(content)
+	if result := searcher.MatchCorpus("1.2", corpus); len(result) != 3 {
+		t.Errorf("expected 3 matches, got %v", result)
+	}
+	// The results of the custom algorithm are not cached
+	if result := searcher.MatchCorpus("1.20", corpus); len(result) != 1 {
+		t.Errorf("expected 1 match, got %v", result)
+	}
+	opts.Extended = false
+	if result := NewSearcher(opts).Match("1.2", content); len(result) != 3 {
+		t.Errorf("expected 3 matches, got %v", result)
+	}
+}
+
+func TestFuzzyMatchTypos(t *testing.T) {
+	content := [][]byte{
+		[]byte("receive_message"),
+		[]byte("recursive"),
+		[]byte("reciever.go"),
+		[]byte("unrelated"),
+	}
+	opts := DefaultOptions()
+	opts.FuzzyAlgo = algo.FuzzyMatchTypos(1)
+	searcher := NewSearcher(opts)
+
+	// The exact fuzzy match is ranked first, then the transposition which
+	// matches more characters than the omission of an "e"
+	matches := searcher.Match("recieve", content)
+	if len(matches) != 3 || matches[0].Index != 2 || matches[1].Index != 0 || matches[2].Index != 1 {
+		t.Fatalf("expected 'reciever.go', 'receive_message' and 'recursive', got %v", matches)
+	}
+	// The transposed characters are matched
+	if !reflect.DeepEqual(matches[1].Positions, []int{0, 1, 2, 3, 4, 5, 6}) || matches[1].Score >= matches[0].Score {
+		t.Errorf("unexpected match: %v", matches[1])
+	}
+
+	// A missing character and a mismatched character
+	for _, query := range []string{"recive", "recxive"} {
+		matches := searcher.Match(query, content[:1])
+		if len(matches) != 1 || !reflect.DeepEqual(matches[0].Positions, []int{0, 1, 2, 4, 5, 6}) {
+			t.Errorf("%q: unexpected matches: %v", query, matches)
+		}
+	}
+
+	// The edit budget
+	if matches := searcher.Match("rexxive", content); len(matches) != 0 {
+		t.Errorf("expected no match with two edits, got %v", matches)
+	}
+	if matches := NewSearcherOf(opts, func(s string) string { return s }).Match("rxceive", []string{"recursive"}); len(matches) != 0 {
+		t.Errorf("expected no match with two edits, got %v", matches)
+	}
+	opts.FuzzyAlgo = algo.FuzzyMatchTypos(2)
+	if matches := NewSearcher(opts).Match("rexxive", content); len(matches) != 3 {
+		t.Errorf("expected 3 matches with two edits, got %v", matches)
+	}
+	// Short patterns do not tolerate typos
+	if matches := NewSearcher(opts).Match("xn", content); len(matches) != 0 {
+		t.Errorf("expected no match for a short pattern, got %v", matches)
+	}
+
+	// The last occurrence is found when scanning backward
+	typos := algo.FuzzyMatchTypos(1)
+	text := util.ToChars([]byte("recxeve/ünï/recyeve"))
+	for forward, expected := range map[bool][]int{true: {0, 1, 2, 4, 5, 6}, false: {12, 13, 14, 16, 17, 18}} {
+		if res, pos := typos(false, false, forward, &text, []rune("recieve"), true, nil); res.Start != expected[0] || !reflect.DeepEqual(*pos, expected) {
+			t.Errorf("forward %v: expected positions %v, got %v %v", forward, expected, res, *pos)
+		}
+	}
+
+	// Long items are not matched with typos
+	long := util.ToChars([]byte("recive" + strings.Repeat(" ", 100*1024)))
+	if res, _ := typos(false, false, true, &long, []rune("recieve"), false, nil); res.Start >= 0 {
+		t.Errorf("expected no match in a long item, got %v", res)
+	}
+
+	// Typos can also be tolerated per term
+	opts = DefaultOptions()
+	opts.CustomTerms = []CustomTerm{{Prefix: "~", Algo: algo.FuzzyMatchTypos(1)}}
+	searcher = NewSearcher(opts)
+	if matches := searcher.Match("~recieve message", content); len(matches) != 1 || matches[0].Index != 0 {
+		t.Errorf("expected 'receive_message', got %v", matches)
+	}
+	if matches := searcher.Match("recieve message", content); len(matches) != 0 {
+		t.Errorf("expected no match without the prefix, got %v", matches)
+	}
+}
+
+// The scores of fzf for the golden cases of its algorithm tests
+func TestFuzzyMatchGolden(t *testing.T) {
+	for _, c := range []struct {
+		text          string
+		pattern       string
+		caseSensitive bool
+		start, end    int
+		score         int
+	}{
+		{"fooBarbaz1", "oBZ", false, 2, 9, 49},
+		{"foo bar baz", "fbb", false, 0, 9, 78},
+		{"/AutomatorDocument.icns", "rdoc", false, 9, 13, 79},
+		{"/man1/zshcompctl.1", "zshc", false, 6, 10, 109},
+		{"/.oh-my-zsh/cache", "zshc", false, 8, 13, 102},
+		{"ab0123 456", "12356", false, 3, 10, 88},
+		{"abc123 456", "12356", false, 3, 10, 108},
+		{"foo/bar/baz", "fbb", false, 0, 9, 76},
+		{"fooBarBaz", "fbb", false, 0, 7, 74},
+		{"foo barbaz", "fbb", false, 0, 8, 69},
+		{"fooBar Baz", "foob", false, 0, 4, 114},
+		{"xFoo-Bar Baz", "foo-b", false, 1, 6, 124},
+		{"fooBarbaz", "oBz", true, 2, 9, 49},
+		{"Foo/Bar/Baz", "FBB", true, 0, 9, 76},
+		{"FooBarBaz", "FBB", true, 0, 7, 74},
+		{"FooBar Baz", "FooB", true, 0, 4, 114},
+		{"foo-bar", "o-ba", true, 2, 6, 88},
+		{"fooBarbaz", "oBZ", true, -1, -1, 0},
+		{"Foo Bar Baz", "fbb", true, -1, -1, 0},
+		{"fooBarbaz", "fooBarbazz", true, -1, -1, 0},
+	} {
+		pattern := c.pattern
+		if !c.caseSensitive {
+			pattern = strings.ToLower(pattern)
+		}
+		for name, fn := range map[string]algo.Algo{"v1": algo.FuzzyMatchV1, "v2": algo.FuzzyMatchV2} {
+			for _, forward := range []bool{true, false} {
+				chars := util.ToChars([]byte(c.text))
+				res, pos := fn(c.caseSensitive, false, forward, &chars, []rune(pattern), true, nil)
+				// The start offset is only accurate in the positions
+				if pos != nil && len(*pos) > 0 {
+					sort.Ints(*pos)
+					res.Start, res.End = (*pos)[0], (*pos)[len(*pos)-1]+1
+				}
+				if res.Start != c.start || res.End != c.end || res.Score != c.score {
+					t.Errorf("%s %q on %q (forward: %v): expected %d-%d with %d, got %+v",
+						name, c.pattern, c.text, forward, c.start, c.end, c.score, res)
+				}
+			}
+		}
+	}
+
+	// A match after a space is worth more than after a delimiter, which is worth
+	// more than after any other non-word character
+	opts := DefaultOptions()
+	opts.NoSort = true
+	matches := NewSearcher(opts).Match("bar", [][]byte{[]byte("foo bar"), []byte("foo/bar"), []byte("foo-bar")})
+	if len(matches) != 3 || matches[0].Score <= matches[1].Score || matches[1].Score <= matches[2].Score {
+		t.Errorf("unexpected scores: %v", matches)
+	}
+
+	// The delimiters are configurable
+	opts.Scheme = algo.DefaultScheme.WithDelimiters("-")
+	matches = NewSearcher(opts).Match("bar", [][]byte{[]byte("foo/bar"), []byte("foo-bar")})
+	if len(matches) != 2 || matches[0].Score >= matches[1].Score {
+		t.Errorf("unexpected scores with custom delimiters: %v", matches)
+	}
+}
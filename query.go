@@ -0,0 +1,377 @@
+package fzflib
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/bookreport/fzflib/algo"
+)
+
+// Query is a search query in the extended-search syntax. An item matches the
+// query if it matches all of its groups.
+type Query struct {
+	Groups []TermGroup
+}
+
+// TermGroup is a list of alternative terms separated by "|". An item matches
+// the group if it matches any of its terms.
+type TermGroup struct {
+	Terms []Term
+}
+
+// Term is a single search term of a query
+type Term struct {
+	Kind TermKind
+
+	// Inverse is true if the term excludes the items that match it
+	Inverse bool
+
+	// Text is the text of the term without the modifiers that determine its
+	// kind and inversion, and with the escaped spaces unescaped. It is the
+	// expression of a TermRegex.
+	Text string
+
+	CaseSensitive bool
+
+	// Nth limits the term to the given fields of the items. The fields of
+	// Options.Nth are searched if it is empty.
+	Nth []Range
+
+	// Span is the location of the term in the query it was parsed from
+	Span Span
+}
+
+// Span is a [Start, End) range of byte offsets in a query
+type Span struct {
+	Start int
+	End   int
+}
+
+var (
+	errEmptyTerm   = errors.New("empty term")
+	errEmptyGroup  = errors.New("empty group")
+	errTrailingOr  = errors.New("missing alternative after |")
+	errInvalidKind = errors.New("invalid term kind")
+)
+
+// queryToken is a space-separated token of a query
+type queryToken struct {
+	text string
+	span Span
+}
+
+// splitQuery splits the query on unescaped spaces. Spaces escaped with a
+// backslash are part of the tokens.
+func splitQuery(str string) []queryToken {
+	tokens := []queryToken{}
+	var text strings.Builder
+	begin := -1
+	for idx := 0; idx < len(str); idx++ {
+		if str[idx] == ' ' {
+			if begin >= 0 {
+				tokens = append(tokens, queryToken{text.String(), Span{begin, idx}})
+				text.Reset()
+				begin = -1
+			}
+			continue
+		}
+		if begin < 0 {
+			begin = idx
+		}
+		if str[idx] == '\\' && idx+1 < len(str) && str[idx+1] == ' ' {
+			idx++
+		}
+		text.WriteByte(str[idx])
+	}
+	if begin >= 0 {
+		tokens = append(tokens, queryToken{text.String(), Span{begin, len(str)}})
+	}
+	return tokens
+}
+
+// ParseQuery parses the query in the extended-search syntax. The kind of the
+// terms without modifiers and their case-sensitivity are determined by
+// opts.Fuzzy and opts.Case, and field scopes are only parsed if
+// opts.FieldScopes is set. The fields cannot be named after the columns of a
+// header, see Searcher.ParseQuery. A ParseError is returned for a malformed
+// term, a term that is empty without its modifiers or a trailing "|".
+func ParseQuery(str string, opts Options) (*Query, error) {
+	return parseQuery(str, opts.Fuzzy, opts.Case, opts.FieldScopes, nil, opts.CustomTerms, true)
+}
+
+// ParseQuery is ParseQuery with the options of the Searcher, where the fields
+// can also be named after the columns of the header of the corpus
+func (s *Searcher) ParseQuery(str string, corpus *Corpus) (*Query, error) {
+	return parseQuery(str, s.opts.Fuzzy, s.opts.Case, s.opts.FieldScopes, corpus.fieldNames(), s.opts.CustomTerms, true)
+}
+
+// parseQuery parses the query. Field scopes are parsed if fieldScopes is set,
+// and the given field names can be used as such. The terms with the prefixes
+// of the custom terms are parsed as such. Unless strict is set, empty terms
+// and a trailing "|" are ignored as the user may still be typing them.
+func parseQuery(str string, fuzzy bool, caseMode CaseMode, fieldScopes bool, fields []string, customTerms []CustomTerm, strict bool) (*Query, error) {
+	query := &Query{}
+	group := TermGroup{}
+	switchSet := false
+	afterBar := false
+	var lastBar queryToken
+	for _, token := range splitQuery(str) {
+		if len(group.Terms) > 0 && !afterBar && token.text == "|" {
+			switchSet = false
+			afterBar = true
+			lastBar = token
+			continue
+		}
+		afterBar = false
+
+		term, err := parseTerm(token, fuzzy, caseMode, fieldScopes, fields, customTerms)
+		if err == errEmptyTerm && !strict {
+			continue
+		} else if err != nil {
+			return nil, &ParseError{Term: token.text, Span: token.span, Err: err}
+		}
+
+		if switchSet {
+			query.Groups = append(query.Groups, group)
+			group = TermGroup{}
+		}
+		group.Terms = append(group.Terms, term)
+		switchSet = true
+	}
+	if afterBar && strict {
+		return nil, &ParseError{Term: lastBar.text, Span: lastBar.span, Err: errTrailingOr}
+	}
+	if len(group.Terms) > 0 {
+		query.Groups = append(query.Groups, group)
+	}
+	return query, nil
+}
+
+func parseTerm(token queryToken, fuzzy bool, caseMode CaseMode, fieldScopes bool, fields []string, customTerms []CustomTerm) (Term, error) {
+	typ, inv, text := TermFuzzy, false, token.text
+
+	// Field scope such as "2:", "-1,3..:" or "name:"
+	var nth []Range
+	if idx := strings.IndexByte(text, ':'); fieldScopes && idx > 0 {
+		if ranges, err := ParseNth(text[:idx]); err == nil {
+			nth = ranges
+			text = text[idx+1:]
+		} else if field := fieldIndex(text[:idx], fields); field > 0 {
+			nth = []Range{{field, field}}
+			text = text[idx+1:]
+		}
+	}
+
+	caseSensitive := caseMode == CaseRespect ||
+		caseMode == CaseSmart && text != strings.ToLower(text)
+	if !fuzzy {
+		typ = TermExact
+	}
+
+	if strings.HasPrefix(text, "!") {
+		inv = true
+		typ = TermExact
+		text = text[1:]
+	}
+
+	if kind, found := customKind(text, customTerms); found {
+		typ = kind
+		text = text[len(customTerms[kind-TermCustom].Prefix):]
+	} else if len(text) > 2 && strings.HasPrefix(text, "/") && strings.HasSuffix(text, "/") {
+		typ = TermRegex
+		text = text[1 : len(text)-1]
+		if caseMode == CaseSmart {
+			caseSensitive = hasUpperOutsideEscapes(text)
+		}
+		if _, err := algo.CompileRegex(text, caseSensitive); err != nil {
+			return Term{}, fmt.Errorf("invalid regular expression: %w", err)
+		}
+	} else {
+		if text != "$" && strings.HasSuffix(text, "$") {
+			typ = TermSuffix
+			text = text[:len(text)-1]
+		}
+
+		if strings.HasPrefix(text, "'") {
+			// Flip exactness
+			if fuzzy && !inv {
+				typ = TermExact
+				text = text[1:]
+			} else {
+				typ = TermFuzzy
+				text = text[1:]
+			}
+		} else if strings.HasPrefix(text, "^") {
+			if typ == TermSuffix {
+				typ = TermEqual
+			} else {
+				typ = TermPrefix
+			}
+			text = text[1:]
+		}
+	}
+
+	if len(text) == 0 {
+		return Term{}, errEmptyTerm
+	}
+	return Term{
+		Kind:          typ,
+		Inverse:       inv,
+		Text:          text,
+		CaseSensitive: caseSensitive,
+		Nth:           nth,
+		Span:          token.span}, nil
+}
+
+// hasUpperOutsideEscapes returns true if the regular expression has an
+// uppercase letter that is not part of an escape sequence such as \S, \W or
+// \p{Lu}
+func hasUpperOutsideEscapes(expr string) bool {
+	for idx := 0; idx < len(expr); {
+		char, size := utf8.DecodeRuneInString(expr[idx:])
+		if char == '\\' && idx+1 < len(expr) {
+			// Skip the escaped character, and the name of a Unicode class
+			next := expr[idx+1]
+			idx += 2
+			if (next == 'p' || next == 'P') && idx < len(expr) {
+				if expr[idx] != '{' {
+					idx++
+				} else if end := strings.IndexByte(expr[idx:], '}'); end >= 0 {
+					idx += end + 1
+				}
+			}
+			continue
+		}
+		if unicode.IsUpper(char) {
+			return true
+		}
+		idx += size
+	}
+	return false
+}
+
+// customKind returns the kind of the first custom term whose prefix the text
+// starts with
+func customKind(text string, customTerms []CustomTerm) (TermKind, bool) {
+	for idx, customTerm := range customTerms {
+		if len(customTerm.Prefix) > 0 && strings.HasPrefix(text, customTerm.Prefix) {
+			return TermCustom + TermKind(idx), true
+		}
+	}
+	return 0, false
+}
+
+// fieldIndex returns the 1-based index of the field with the given name, or
+// zero if there is no such field. Names are case-insensitive.
+func fieldIndex(name string, fields []string) int {
+	for idx, field := range fields {
+		if strings.EqualFold(field, name) {
+			return idx + 1
+		}
+	}
+	return 0
+}
+
+// termSets converts the query into the termSets of a pattern. The kinds of
+// the terms must have algorithms in procFun. The expressions of regex terms
+// are compiled once and matched by regexAlgo unless it is nil.
+func (q *Query) termSets(normalize bool, procFun map[TermKind]algo.Algo, regexAlgo func(*regexp.Regexp) algo.Algo) ([]termSet, error) {
+	sets := []termSet{}
+	for _, group := range q.Groups {
+		if len(group.Terms) == 0 {
+			return nil, &ParseError{Err: errEmptyGroup}
+		}
+		set := termSet{}
+		for _, t := range group.Terms {
+			text := t.Text
+			pfun := procFun[t.Kind]
+			switch {
+			case len(text) == 0:
+				return nil, &ParseError{Term: t.String(), Span: t.Span, Err: errEmptyTerm}
+			case procFun[t.Kind] == nil:
+				return nil, &ParseError{Term: t.String(), Span: t.Span, Err: errInvalidKind}
+			case t.Kind == TermRegex:
+				// The expression keeps its case, see algo.RegexMatch, but its
+				// literal characters are normalized like the text
+				expr := text
+				if normalize {
+					expr = string(algo.NormalizeRunes([]rune(text)))
+				}
+				re, err := algo.CompileRegex(expr, t.CaseSensitive)
+				if err != nil {
+					return nil, &ParseError{Term: t.String(), Span: t.Span, Err: fmt.Errorf("invalid regular expression: %w", err)}
+				}
+				if regexAlgo != nil {
+					pfun = regexAlgo(re)
+				}
+			case !t.CaseSensitive:
+				text = strings.ToLower(text)
+			}
+
+			textRunes := []rune(text)
+			if normalize {
+				textRunes = algo.NormalizeRunes(textRunes)
+			}
+			set = append(set, term{
+				typ:           t.Kind,
+				inv:           t.Inverse,
+				text:          textRunes,
+				caseSensitive: t.CaseSensitive,
+				nth:           t.Nth,
+				algo:          pfun})
+		}
+		sets = append(sets, set)
+	}
+	return sets, nil
+}
+
+// String returns the term in the extended-search syntax of fuzzy mode. The
+// text of a fuzzy term without fields that starts with a field scope, such as
+// "12:30", is taken as the scope if the string is parsed again with
+// Options.FieldScopes. Custom terms are written without their prefixes which
+// are not known to the term.
+func (t Term) String() string {
+	text := strings.Replace(t.Text, " ", "\\ ", -1)
+	switch t.Kind {
+	case TermFuzzy:
+		if t.Inverse {
+			text = "'" + text
+		}
+	case TermExact:
+		if !t.Inverse {
+			text = "'" + text
+		}
+	case TermPrefix:
+		text = "^" + text
+	case TermSuffix:
+		text = text + "$"
+	case TermEqual:
+		text = "^" + text + "$"
+	case TermRegex:
+		text = "/" + text + "/"
+	}
+	if t.Inverse {
+		text = "!" + text
+	}
+	if len(t.Nth) > 0 {
+		text = formatNth(t.Nth) + ":" + text
+	}
+	return text
+}
+
+// String returns the query in the extended-search syntax of fuzzy mode
+func (q *Query) String() string {
+	groups := make([]string, len(q.Groups))
+	for idx, group := range q.Groups {
+		terms := make([]string, len(group.Terms))
+		for idx, t := range group.Terms {
+			terms[idx] = t.String()
+		}
+		groups[idx] = strings.Join(terms, " | ")
+	}
+	return strings.Join(groups, " ")
+}
@@ -0,0 +1,144 @@
+package fzflib
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseQuery(t *testing.T) {
+	query, err := ParseQuery(`^src foo\ bar | !'Baz /\d+/ .go$`, DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &Query{Groups: []TermGroup{
+		{Terms: []Term{{Kind: TermPrefix, Text: "src", Span: Span{0, 4}}}},
+		{Terms: []Term{
+			{Kind: TermFuzzy, Text: "foo bar", Span: Span{5, 13}},
+			{Kind: TermFuzzy, Inverse: true, Text: "Baz", CaseSensitive: true, Span: Span{16, 21}}}},
+		{Terms: []Term{{Kind: TermRegex, Text: `\d+`, Span: Span{22, 27}}}},
+		{Terms: []Term{{Kind: TermSuffix, Text: ".go", Span: Span{28, 32}}}},
+	}}
+	if !reflect.DeepEqual(query, expected) {
+		t.Errorf("expected %+v, got %+v", expected, query)
+	}
+	if str := query.String(); str != `^src foo\ bar | !'Baz /\d+/ .go$` {
+		t.Errorf("unexpected string representation: %s", str)
+	}
+
+	opts := DefaultOptions()
+	opts.Fuzzy = false
+	if query, _ := ParseQuery("foo 'bar !baz", opts); query.Groups[0].Terms[0].Kind != TermExact ||
+		query.Groups[1].Terms[0].Kind != TermFuzzy || query.Groups[2].Terms[0].Kind != TermExact {
+		t.Errorf("unexpected kinds in exact mode: %+v", query)
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	for str, expected := range map[string]Span{
+		"foo !":       {4, 5},
+		"foo | ^":     {6, 7},
+		"foo | bar |": {10, 11},
+		"foo /(/":     {4, 7},
+	} {
+		_, err := ParseQuery(str, DefaultOptions())
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) || parseErr.Span != expected {
+			t.Errorf("%q: expected a ParseError at %v, got %v", str, expected, err)
+		}
+	}
+
+	// The Searcher ignores incomplete terms
+	if result := Search("daily !", [][]byte{[]byte("daily")}); len(result) != 1 {
+		t.Errorf("expected the incomplete term to be ignored, got %q", result)
+	}
+}
+
+func TestMatchQueryContext(t *testing.T) {
+	content := [][]byte{
+		[]byte("src/main.go"),
+		[]byte("src/main_test.go"),
+		[]byte("docs/main.md"),
+	}
+	query := &Query{Groups: []TermGroup{
+		{Terms: []Term{{Kind: TermPrefix, Text: "src"}}},
+		{Terms: []Term{{Kind: TermExact, Inverse: true, Text: "_test"}}},
+	}}
+
+	matches, err := NewSearcher(DefaultOptions()).MatchQueryContext(context.Background(), query, corpusOf(content))
+	if err != nil || len(matches) != 1 || matches[0].Index != 0 {
+		t.Errorf("expected only 'src/main.go', got %v (%v)", matches, err)
+	}
+
+	query.Groups[0].Terms[0].Text = ""
+	if _, err := NewSearcher(DefaultOptions()).MatchQueryContext(context.Background(), query, corpusOf(content)); err == nil {
+		t.Error("expected an error for an empty term")
+	}
+}
+
+func TestFieldScopedTerms(t *testing.T) {
+	opts := DefaultOptions()
+	opts.FieldScopes = true
+	query, err := ParseQuery(`2:foo -1:^Bar 1..2,4:!baz '12:30 http:// +2:x`, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Term{
+		{Kind: TermFuzzy, Text: "foo", Nth: []Range{{2, 2}}, Span: Span{0, 5}},
+		{Kind: TermPrefix, Text: "Bar", CaseSensitive: true, Nth: []Range{{-1, -1}}, Span: Span{6, 13}},
+		{Kind: TermExact, Inverse: true, Text: "baz", Nth: []Range{{1, 2}, {4, 4}}, Span: Span{14, 25}},
+	}
+	expected = append(expected,
+		Term{Kind: TermExact, Text: "12:30", Span: Span{26, 32}},
+		Term{Kind: TermFuzzy, Text: "http://", Span: Span{33, 40}},
+		Term{Kind: TermFuzzy, Text: "+2:x", Span: Span{41, 45}})
+	for idx, term := range expected {
+		if !reflect.DeepEqual(query.Groups[idx].Terms[0], term) {
+			t.Errorf("expected %+v, got %+v", term, query.Groups[idx].Terms[0])
+		}
+	}
+	if str := query.String(); str != `2:foo -1:^Bar 1..2,4:!baz '12:30 http:// +2:x` {
+		t.Errorf("unexpected string representation: %s", str)
+	}
+
+	content := [][]byte{
+		[]byte("foo bar baz"),
+		[]byte("bar foo baz"),
+		[]byte("baz qux foo"),
+	}
+	searcher := NewSearcher(opts)
+	matches, err := searcher.MatchCorpusContext(context.Background(), "2:foo -1:^baz", corpusOf(content))
+	if err != nil || len(matches) != 1 || matches[0].Index != 1 {
+		t.Fatalf("expected only 'bar foo baz', got %v (%v)", matches, err)
+	}
+	// Offsets are relative to the whole item
+	if !reflect.DeepEqual(matches[0].Offsets, [][2]int32{{4, 7}, {8, 11}}) {
+		t.Errorf("unexpected offsets: %v", matches[0].Offsets)
+	}
+	if !reflect.DeepEqual(matches[0].Positions, []int{4, 5, 6, 8, 9, 10}) {
+		t.Errorf("unexpected positions: %v", matches[0].Positions)
+	}
+
+	// Scoped terms override the fields of the searcher
+	opts.Nth = []Range{{1, 1}}
+	if result := NewSearcher(opts).Search("3:foo", content); len(result) != 1 || string(result[0]) != "baz qux foo" {
+		t.Errorf("expected only 'baz qux foo', got %q", result)
+	}
+
+	// The results of a scoped term are not cached as those of the unscoped term
+	corpus := corpusOf(content)
+	searcher.MatchCorpus("1:foo", corpus)
+	if matches := searcher.MatchCorpus("foo", corpus); len(matches) != 3 {
+		t.Errorf("expected 3 matches, got %d", len(matches))
+	}
+
+	// Field scopes are disabled by default
+	query, _ = ParseQuery("12:30", DefaultOptions())
+	if term := query.Groups[0].Terms[0]; term.Kind != TermFuzzy || term.Text != "12:30" || term.Nth != nil {
+		t.Errorf("expected a fuzzy term, got %+v", term)
+	}
+	if result := Search("12:30", [][]byte{[]byte("lunch at 12:30"), []byte("1 2 3 0")}); len(result) != 1 {
+		t.Errorf("expected only 'lunch at 12:30', got %q", result)
+	}
+}
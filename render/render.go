@@ -0,0 +1,126 @@
+// Package render turns the positions of the matched characters into
+// highlighted output.
+//
+// Positions are rune indexes into the text of an item as reported by
+// fzflib.Match. Both the byte-backed (ASCII) and the rune-backed
+// representations of util.Chars are supported:
+//
+//	chars := util.ToChars(match.Text)
+//	fmt.Println(render.ANSI(&chars, match.Positions, render.DefaultStyle))
+package render
+
+import (
+	"html"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bookreport/fzflib/util"
+)
+
+// DefaultStyle is the SGR parameter used by ANSI to highlight the matches
+// (bold green)
+const DefaultStyle = "1;32"
+
+// Range is a [Start, End) range of indexes
+type Range struct {
+	Start int
+	End   int
+}
+
+// Ranges merges the positions into ranges of consecutive rune indexes. The
+// positions do not have to be sorted and may contain duplicates.
+func Ranges(positions []int) []Range {
+	if len(positions) == 0 {
+		return nil
+	}
+
+	sorted := make([]int, len(positions))
+	copy(sorted, positions)
+	sort.Ints(sorted)
+
+	ranges := []Range{{sorted[0], sorted[0] + 1}}
+	for _, pos := range sorted[1:] {
+		last := &ranges[len(ranges)-1]
+		if pos < last.End {
+			continue
+		}
+		if pos == last.End {
+			last.End++
+		} else {
+			ranges = append(ranges, Range{pos, pos + 1})
+		}
+	}
+	return ranges
+}
+
+// clip returns the ranges of the positions that are within the text
+func clip(text *util.Chars, positions []int) []Range {
+	length := text.Length()
+	ranges := Ranges(positions)
+	clipped := ranges[:0]
+	for _, r := range ranges {
+		r.Start = util.Max(r.Start, 0)
+		r.End = util.Min(r.End, length)
+		if r.Start < r.End {
+			clipped = append(clipped, r)
+		}
+	}
+	return clipped
+}
+
+// ByteRanges is Ranges with the rune indexes converted to byte offsets in the
+// UTF-8 encoding of the text. Positions outside of the text are ignored.
+func ByteRanges(text *util.Chars, positions []int) []Range {
+	ranges := clip(text, positions)
+	if text.IsBytes() {
+		// Rune indexes are byte offsets for ASCII text
+		return ranges
+	}
+
+	offset, idx := 0, 0
+	for i := range ranges {
+		for ; idx < ranges[i].Start; idx++ {
+			offset += utf8.RuneLen(text.Get(idx))
+		}
+		ranges[i].Start = offset
+		for ; idx < ranges[i].End; idx++ {
+			offset += utf8.RuneLen(text.Get(idx))
+		}
+		ranges[i].End = offset
+	}
+	return ranges
+}
+
+// highlight returns the text with each matched range wrapped by the prefix
+// and the suffix. Every part of the text is passed through escape.
+func highlight(text *util.Chars, positions []int, prefix string, suffix string, escape func(string) string) string {
+	str := text.ToString()
+	var output strings.Builder
+	last := 0
+	for _, r := range ByteRanges(text, positions) {
+		output.WriteString(escape(str[last:r.Start]))
+		output.WriteString(prefix)
+		output.WriteString(escape(str[r.Start:r.End]))
+		output.WriteString(suffix)
+		last = r.End
+	}
+	output.WriteString(escape(str[last:]))
+	return output.String()
+}
+
+func noEscape(str string) string {
+	return str
+}
+
+// ANSI returns the text with the matched characters highlighted by the SGR
+// escape sequence with the given parameters, e.g. DefaultStyle
+func ANSI(text *util.Chars, positions []int, style string) string {
+	return highlight(text, positions, "\x1b["+style+"m", "\x1b[0m", noEscape)
+}
+
+// HTML returns the HTML-escaped text with the matched characters wrapped in
+// <mark> elements
+func HTML(text *util.Chars, positions []int) string {
+	return highlight(text, positions, "<mark>", "</mark>", html.EscapeString)
+}
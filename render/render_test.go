@@ -0,0 +1,43 @@
+package render
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bookreport/fzflib/util"
+)
+
+func TestRanges(t *testing.T) {
+	ranges := Ranges([]int{7, 1, 2, 3, 3, 5, 8})
+	expected := []Range{{1, 4}, {5, 6}, {7, 9}}
+	if !reflect.DeepEqual(ranges, expected) {
+		t.Errorf("expected %v, got %v", expected, ranges)
+	}
+	if ranges := Ranges(nil); ranges != nil {
+		t.Errorf("expected no ranges, got %v", ranges)
+	}
+}
+
+func TestByteRanges(t *testing.T) {
+	ascii := util.ToChars([]byte("foo-bar"))
+	if ranges := ByteRanges(&ascii, []int{0, 4, 5, 10}); !reflect.DeepEqual(ranges, []Range{{0, 1}, {4, 6}}) {
+		t.Errorf("unexpected ranges for byte-backed text: %v", ranges)
+	}
+
+	unicode := util.ToChars([]byte("fö-bär"))
+	if ranges := ByteRanges(&unicode, []int{1, 4, 5}); !reflect.DeepEqual(ranges, []Range{{1, 3}, {5, 8}}) {
+		t.Errorf("unexpected ranges for rune-backed text: %v", ranges)
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	ascii := util.ToChars([]byte("a<b>c"))
+	if html := HTML(&ascii, []int{0, 2}); html != "<mark>a</mark>&lt;<mark>b</mark>&gt;c" {
+		t.Errorf("unexpected HTML: %q", html)
+	}
+
+	unicode := util.ToChars([]byte("fö-bär"))
+	if ansi := ANSI(&unicode, []int{1, 4}, DefaultStyle); ansi != "f\x1b[1;32mö\x1b[0m-b\x1b[1;32mä\x1b[0mr" {
+		t.Errorf("unexpected ANSI output: %q", ansi)
+	}
+}
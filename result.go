@@ -1,23 +1,59 @@
 package fzflib
 
 import (
+	"container/heap"
+	"errors"
+	"fmt"
 	"math"
+	"os"
 	"sort"
+	"strings"
 	"unicode"
 
+	"github.com/bookreport/fzflib/algo"
 	"github.com/bookreport/fzflib/util"
 )
 
-// Sort criteria
-type criterion int
+// Criterion is a sort criterion used to rank the matched items
+type Criterion int
 
 const (
-	byScore criterion = iota
-	byLength
-	byBegin
-	byEnd
+	ByScore Criterion = iota
+	ByLength
+	ByBegin
+	ByEnd
+	// ByIndex ranks the items by their order in the input. As it is always
+	// the final tiebreaker, the criteria following it have no effect.
+	ByIndex
+	// ByPathname ranks the items whose match starts in the last component of
+	// the path higher, the closer to its start the better
+	ByPathname
 )
 
+// String returns the name of the criterion, e.g. "length"
+func (c Criterion) String() string {
+	switch c {
+	case ByScore:
+		return "score"
+	case ByLength:
+		return "length"
+	case ByBegin:
+		return "begin"
+	case ByEnd:
+		return "end"
+	case ByIndex:
+		return "index"
+	case ByPathname:
+		return "pathname"
+	}
+	return fmt.Sprintf("Criterion(%d)", int(c))
+}
+
+// historyLengthStep is the difference of length that ByLength ignores
+// between the items searched with algo.HistoryScheme or a copy of it, so that
+// the items of similar length keep their chronological order
+const historyLengthStep = 16
+
 // substrOffset holds two 32-bit integers denoting the offsets of a matched substring
 type substrOffset [2]int32
 
@@ -26,7 +62,7 @@ type result struct {
 	points [4]uint16
 }
 
-func buildResult(item *item, offsets []substrOffset, score int) result {
+func buildResult(item *item, offsets []substrOffset, score int, sortCriteria []Criterion, scheme *algo.Scheme) result {
 	if len(offsets) > 1 {
 		sort.Sort(byOrder(offsets))
 	}
@@ -48,14 +84,33 @@ func buildResult(item *item, offsets []substrOffset, score int) result {
 	}
 
 	for idx, criterion := range sortCriteria {
+		if criterion == ByIndex {
+			break
+		}
 		val := uint16(math.MaxUint16)
 		switch criterion {
-		case byScore:
+		case ByScore:
 			// Higher is better
 			val = math.MaxUint16 - util.AsUint16(score)
-		case byLength:
+		case ByLength:
 			val = item.TrimLength()
-		case byBegin, byEnd:
+			if scheme.GroupsLength() {
+				val /= historyLengthStep
+			}
+		case ByPathname:
+			if validOffsetFound {
+				lastDelim := -1
+				for idx := numChars - 1; idx >= 0; idx-- {
+					if isPathSeparator(item.text.Get(idx)) {
+						lastDelim = idx
+						break
+					}
+				}
+				if lastDelim <= minBegin {
+					val = util.AsUint16(minBegin - lastDelim)
+				}
+			}
+		case ByBegin, ByEnd:
 			if validOffsetFound {
 				whitePrefixLen := 0
 				for idx := 0; idx < numChars; idx++ {
@@ -65,7 +120,7 @@ func buildResult(item *item, offsets []substrOffset, score int) result {
 						break
 					}
 				}
-				if criterion == byBegin {
+				if criterion == ByBegin {
 					val = util.AsUint16(minEnd - whitePrefixLen)
 				} else {
 					val = util.AsUint16(math.MaxUint16 - math.MaxUint16*(maxEnd-whitePrefixLen)/int(item.TrimLength()))
@@ -78,8 +133,9 @@ func buildResult(item *item, offsets []substrOffset, score int) result {
 	return result
 }
 
-// Sort criteria to use. Never changes once fzf is started.
-var sortCriteria []criterion
+func isPathSeparator(r rune) bool {
+	return r == '/' || r == os.PathSeparator
+}
 
 // Index returns ordinal index of the item
 func (result *result) Index() int32 {
@@ -149,3 +205,99 @@ func (a byRelevanceTac) Swap(i, j int) {
 func (a byRelevanceTac) Less(i, j int) bool {
 	return compareRanks(a[i], a[j], true)
 }
+
+// topResults keeps the best results up to the given limit. It is a heap with
+// the worst of the results at its root so that it can be replaced when a
+// better result is found.
+type topResults struct {
+	limit int
+	tac   bool
+	list  []result
+}
+
+func newTopResults(limit int, tac bool) *topResults {
+	return &topResults{limit: limit, tac: tac, list: make([]result, 0, limit)}
+}
+
+func (t *topResults) Len() int {
+	return len(t.list)
+}
+
+func (t *topResults) Swap(i, j int) {
+	t.list[i], t.list[j] = t.list[j], t.list[i]
+}
+
+func (t *topResults) Less(i, j int) bool {
+	return compareRanks(t.list[j], t.list[i], t.tac)
+}
+
+func (t *topResults) Push(x interface{}) {
+	t.list = append(t.list, x.(result))
+}
+
+func (t *topResults) Pop() interface{} {
+	last := t.list[len(t.list)-1]
+	t.list = t.list[:len(t.list)-1]
+	return last
+}
+
+// Add adds the results that rank higher than the ones already kept
+func (t *topResults) Add(results []result) {
+	for _, r := range results {
+		if len(t.list) < t.limit {
+			heap.Push(t, r)
+		} else if compareRanks(r, t.list[0], t.tac) {
+			t.list[0] = r
+			heap.Fix(t, 0)
+		}
+	}
+}
+
+// ParseTiebreak parses a comma-separated list of sort criteria in the format
+// of the --tiebreak option of fzf, e.g. "pathname,length,index". The returned
+// criteria always start with ByScore, which leaves room for three more besides
+// "index". "index" has to be the last criterion as the items are finally
+// ranked by their index anyway.
+func ParseTiebreak(str string) ([]Criterion, error) {
+	criteria := []Criterion{ByScore}
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(strings.ToLower(str), ",") {
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate sort criterion: %s", name)
+		}
+		if seen["index"] {
+			return nil, errors.New("index should be the last criterion")
+		}
+		seen[name] = true
+
+		switch name {
+		case "index":
+			criteria = append(criteria, ByIndex)
+		case "length":
+			criteria = append(criteria, ByLength)
+		case "begin":
+			criteria = append(criteria, ByBegin)
+		case "end":
+			criteria = append(criteria, ByEnd)
+		case "pathname":
+			criteria = append(criteria, ByPathname)
+		default:
+			return nil, fmt.Errorf("invalid sort criterion: %s", name)
+		}
+	}
+	if countPoints(criteria) > len(result{}.points) {
+		return nil, fmt.Errorf("too many sort criteria: %s", str)
+	}
+	return criteria, nil
+}
+
+// countPoints returns the number of points of a result taken up by the
+// criteria. ByIndex does not take up any as it is the last criterion.
+func countPoints(criteria []Criterion) int {
+	for idx, criterion := range criteria {
+		if criterion == ByIndex {
+			return idx
+		}
+	}
+	return len(criteria)
+}
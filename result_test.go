@@ -0,0 +1,158 @@
+package fzflib
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bookreport/fzflib/algo"
+)
+
+func TestParseTiebreak(t *testing.T) {
+	for str, expected := range map[string][]Criterion{
+		"length":                 {ByScore, ByLength},
+		"LENGTH,begin,end":       {ByScore, ByLength, ByBegin, ByEnd},
+		"end,index":              {ByScore, ByEnd, ByIndex},
+		"index":                  {ByScore, ByIndex},
+		"pathname,length":        {ByScore, ByPathname, ByLength},
+		"length,begin,end,index": {ByScore, ByLength, ByBegin, ByEnd, ByIndex},
+	} {
+		criteria, err := ParseTiebreak(str)
+		if err != nil || !reflect.DeepEqual(criteria, expected) {
+			t.Errorf("%q: expected %v, got %v (%v)", str, expected, criteria, err)
+		}
+	}
+
+	for _, str := range []string{"", "size", "length,length", "index,length", "length,begin,end,pathname"} {
+		if _, err := ParseTiebreak(str); err == nil {
+			t.Errorf("%q: expected an error", str)
+		}
+	}
+}
+
+func TestSortModes(t *testing.T) {
+	content := [][]byte{
+		[]byte("foobar baz"),
+		[]byte("xfoobar"),
+		[]byte("foobar"),
+		[]byte("foobar"),
+	}
+	indexes := func(opts Options) []int {
+		var ret []int
+		for _, match := range NewSearcher(opts).Match("foobar", content) {
+			ret = append(ret, match.Index)
+		}
+		return ret
+	}
+
+	opts := DefaultOptions()
+	if result := indexes(opts); !reflect.DeepEqual(result, []int{2, 3, 0, 1}) {
+		t.Errorf("unexpected default order: %v", result)
+	}
+
+	opts.Tac = true
+	if result := indexes(opts); !reflect.DeepEqual(result, []int{3, 2, 0, 1}) {
+		t.Errorf("unexpected order with tac: %v", result)
+	}
+
+	opts.NoSort = true
+	if result := indexes(opts); !reflect.DeepEqual(result, []int{3, 2, 1, 0}) {
+		t.Errorf("unexpected order with tac and no-sort: %v", result)
+	}
+
+	opts.Tac = false
+	if result := indexes(opts); !reflect.DeepEqual(result, []int{0, 1, 2, 3}) {
+		t.Errorf("unexpected order with no-sort: %v", result)
+	}
+
+	opts = DefaultOptions()
+	opts.Tiebreak, _ = ParseTiebreak("index")
+	if result := indexes(opts); !reflect.DeepEqual(result, []int{0, 2, 3, 1}) {
+		t.Errorf("unexpected order with index tiebreak: %v", result)
+	}
+}
+
+func TestSchemes(t *testing.T) {
+	for name, expected := range map[string]*algo.Scheme{
+		"default": algo.DefaultScheme,
+		"Path":    algo.PathScheme,
+		"history": algo.HistoryScheme,
+	} {
+		if scheme, err := algo.ParseScheme(name); err != nil || scheme != expected {
+			t.Errorf("%q: expected %v, got %v (%v)", name, expected, scheme, err)
+		}
+	}
+	if _, err := algo.ParseScheme("paths"); err == nil {
+		t.Error("expected an error for an invalid scheme")
+	}
+
+	search := func(scheme *algo.Scheme, noSort bool, query string, content []string) []Match {
+		opts := DefaultOptions()
+		opts.Scheme = scheme
+		opts.NoSort = noSort
+		var items [][]byte
+		for _, str := range content {
+			items = append(items, []byte(str))
+		}
+		return NewSearcher(opts).Match(query, items)
+	}
+	order := func(scheme *algo.Scheme, query string, content ...string) []int {
+		var ret []int
+		for _, match := range search(scheme, false, query, content) {
+			ret = append(ret, match.Index)
+		}
+		return ret
+	}
+	scores := func(scheme *algo.Scheme, query string, content ...string) []int {
+		var ret []int
+		for _, match := range search(scheme, true, query, content) {
+			ret = append(ret, match.Score)
+		}
+		return ret
+	}
+
+	// The start of a path component is worth more than the start of a word
+	if result := scores(algo.HistoryScheme, "fb", "foo-bar", "foo/bar"); result[0] != result[1] {
+		t.Errorf("expected the same scores with the history scheme, got %v", result)
+	}
+	if result := scores(algo.PathScheme, "fb", "foo-bar", "foo/bar"); result[0] >= result[1] {
+		t.Errorf("expected a higher score after a path separator, got %v", result)
+	}
+
+	// Matches in the basename rank higher
+	if result := order(algo.DefaultScheme, "bar", "bar/x.go", "src/bar.go"); !reflect.DeepEqual(result, []int{0, 1}) {
+		t.Errorf("unexpected order with the default scheme: %v", result)
+	}
+	if result := order(algo.PathScheme, "bar", "bar/x.go", "src/bar.go"); !reflect.DeepEqual(result, []int{1, 0}) {
+		t.Errorf("unexpected order with the path scheme: %v", result)
+	}
+	if result := order(algo.PathScheme.WithDelimiters("/-"), "bar", "bar/x.go", "src/bar.go"); !reflect.DeepEqual(result, []int{1, 0}) {
+		t.Errorf("unexpected order with other delimiters: %v", result)
+	}
+
+	// ByPathname is only added when there is room for it
+	for _, tiebreak := range [][]Criterion{
+		{ByScore, ByLength},
+		{ByScore, ByLength, ByBegin, ByIndex},
+		{ByScore, ByLength, ByBegin, ByEnd},
+		{ByScore, ByLength, ByBegin, ByEnd, ByIndex},
+	} {
+		opts := DefaultOptions()
+		opts.Scheme = algo.PathScheme
+		opts.Tiebreak = tiebreak
+		expected := tiebreak
+		if countPoints(tiebreak) < 4 {
+			expected = append([]Criterion{ByScore, ByPathname}, tiebreak[1:]...)
+		}
+		if result := NewSearcher(opts).opts.Tiebreak; !reflect.DeepEqual(result, expected) {
+			t.Errorf("%v: expected %v, got %v", tiebreak, expected, result)
+		}
+	}
+
+	// Items of similar length keep their order
+	if result := order(algo.DefaultScheme, "git", "git status -s", "git st"); !reflect.DeepEqual(result, []int{1, 0}) {
+		t.Errorf("unexpected order with the default scheme: %v", result)
+	}
+	if result := order(algo.HistoryScheme, "git", "git status -s", "git st"); !reflect.DeepEqual(result, []int{0, 1}) {
+		t.Errorf("unexpected order with the history scheme: %v", result)
+	}
+}
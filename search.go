@@ -1,54 +1,300 @@
 package fzflib
 
 import (
-	"sort"
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/bookreport/fzflib/algo"
 	"github.com/bookreport/fzflib/util"
 )
 
+// Searcher searches lists of items with a fixed set of Options. A Searcher
+// is safe for concurrent use by multiple goroutines.
+type Searcher struct {
+	opts         Options
+	mutex        sync.Mutex
+	patternCache map[string]*pattern
+	cache        *chunkCache
+	cacheCorpus  *Corpus
+	stats        Stats
+	partitions   int
+	slabs        sync.Pool
+}
+
+// NewSearcher returns a new Searcher configured with the given options
+func NewSearcher(opts Options) *Searcher {
+	if opts.FuzzyAlgo == nil {
+		opts.FuzzyAlgo = algo.FuzzyMatchV2
+	}
+	if opts.Scheme == nil {
+		opts.Scheme = algo.DefaultScheme
+	}
+	if opts.Scheme.RanksPathname() && !hasCriterion(opts.Tiebreak, ByPathname) && len(opts.Tiebreak) > 0 && opts.Tiebreak[0] == ByScore && countPoints(opts.Tiebreak) < len(result{}.points) {
+		opts.Tiebreak = append([]Criterion{ByScore, ByPathname}, opts.Tiebreak[1:]...)
+	}
+	if countPoints(opts.Tiebreak) > len(result{}.points) {
+		opts.Tiebreak = opts.Tiebreak[:len(result{}.points)]
+	}
+	return &Searcher{
+		opts:         opts,
+		patternCache: make(map[string]*pattern),
+		partitions:   runtime.GOMAXPROCS(0),
+		slabs: sync.Pool{New: func() interface{} {
+			return util.MakeSlab(slab16Size, slab32Size)
+		}}}
+}
+
+func hasCriterion(criteria []Criterion, criterion Criterion) bool {
+	for _, c := range criteria {
+		if c == criterion {
+			return true
+		}
+	}
+	return false
+}
+
+// Search returns the items of content matching the query using the default
+// options, ordered by relevance
 func Search(query string, content [][]byte) [][]byte {
-	sortCriteria = []criterion{byScore, byLength}
-	patternBuilder := func(runes []rune) *pattern {
-		return buildPattern(
-			true,
-			algo.FuzzyMatchV2,
-			true,
-			searchCaseSmart,
-			true,
-			true,
-			false,
-			make([]exprRange, 0),
-			inputDelimiter{},
-			runes,
-		)
-	}
-
-	var itemIndex int32
-	chunkList := newChunkList(func(item *item, data []byte) bool {
-		item.text = util.ToChars(data)
-		item.text.Index = itemIndex
-		itemIndex++
-		return true
-	})
-
-	var results []result
-	pattern := patternBuilder([]rune(query))
-	slab := util.MakeSlab(slab16Size, slab32Size)
-	for _, c := range content {
-		var i item
-		chunkList.trans(&i, c)
-		if result, _, _ := pattern.MatchItem(&i, false, slab); result != nil {
-			results = append(results, *result)
+	return NewSearcher(DefaultOptions()).Search(query, content)
+}
+
+// getSlab returns a slab that is not used by any other goroutine
+func (s *Searcher) getSlab() *util.Slab {
+	return s.slabs.Get().(*util.Slab)
+}
+
+// putSlab returns the slab to the pool for reuse
+func (s *Searcher) putSlab(slab *util.Slab) {
+	s.slabs.Put(slab)
+}
+
+// cacheFor returns the chunk cache for the corpus. The Searcher only keeps the
+// cache of the corpus that it searched most recently so that it does not hold
+// on to the chunks of the corpora that are no longer in use.
+func (s *Searcher) cacheFor(corpus *Corpus) *chunkCache {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.cache == nil || s.cacheCorpus != corpus {
+		cache := newChunkCache()
+		s.cache, s.cacheCorpus = &cache, corpus
+	}
+	return s.cache
+}
+
+func (s *Searcher) buildPattern(ctx context.Context, query string, fields []string) (*pattern, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// We can uniquely identify the pattern for a given string and field names
+	// since the options of a Searcher do not change once it is created
+	key := query
+	if len(fields) > 0 {
+		key = strings.Join(fields, "\t") + "\n" + query
+	}
+	if cached, found := s.patternCache[key]; found {
+		if s.logEnabled(ctx) {
+			s.logDebug(ctx, "fzflib: pattern cache hit", slog.String("query", query))
+		}
+		return cached, nil
+	}
+	ptr, err := buildPattern(
+		s.opts.Fuzzy,
+		s.opts.FuzzyAlgo,
+		s.opts.Algos,
+		s.opts.CustomTerms,
+		s.opts.Extended,
+		s.opts.Case,
+		s.opts.Normalize,
+		s.opts.Forward,
+		true,
+		s.opts.Nth,
+		s.opts.Delimiter,
+		s.opts.Tiebreak,
+		s.opts.Scheme,
+		s.opts.FieldScopes,
+		fields,
+		nil,
+		[]rune(query),
+	)
+	if err != nil {
+		if s.logEnabled(ctx) {
+			s.logDebug(ctx, "fzflib: invalid query", slog.String("query", query), slog.Any("error", err))
 		}
+		return nil, err
+	}
+	if s.logEnabled(ctx) {
+		s.logDebug(ctx, "fzflib: pattern built",
+			slog.String("query", query),
+			slog.Bool("extended", ptr.extended),
+			slog.Int("termSets", len(ptr.termSets)),
+			slog.Bool("cacheable", ptr.cacheable),
+			slog.String("cacheKey", ptr.cacheKey))
 	}
+	s.patternCache[key] = ptr
+	return ptr, nil
+}
 
-	sort.Sort(byRelevance(results))
+// NewCorpus returns a new empty Corpus whose items are transformed with
+// Options.WithNth when they are pushed, and whose first Options.HeaderLines
+// items are held aside as the header. The items are also split into fields
+// with Options.Delimiter for Options.Nth and field-scoped terms, which costs
+// memory but spares splitting them on every search.
+func (s *Searcher) NewCorpus() *Corpus {
+	return newCorpus(s.opts.WithNth, s.opts.Delimiter, s.opts.HeaderLines, len(s.opts.Nth) > 0 || s.opts.FieldScopes)
+}
 
+// Search returns the items of content matching the query, ordered by
+// relevance
+func (s *Searcher) Search(query string, content [][]byte) [][]byte {
 	var resultsByteSlices [][]byte
-	for _, r := range results {
-		resultsByteSlices = append(resultsByteSlices, r.item.text.Bytes())
+	for _, match := range s.Match(query, content) {
+		resultsByteSlices = append(resultsByteSlices, match.Original)
 	}
 
 	return resultsByteSlices
 }
+
+// Match returns the matches for the query in content, ordered by relevance.
+// No matches are returned for a malformed query.
+func (s *Searcher) Match(query string, content [][]byte) []Match {
+	corpus := s.NewCorpus()
+	for _, data := range content {
+		corpus.Push(data)
+	}
+	matches, _ := s.match(context.Background(), query, corpus.fieldNames(), corpus.snapshot(), nil)
+	return matches
+}
+
+// MatchCorpus returns the matches for the query among the items that have
+// been pushed to the corpus so far, ordered by relevance. The results of
+// previous queries on the same corpus are cached to narrow down the search
+// scope of the subsequent queries. No matches are returned for a malformed
+// query.
+func (s *Searcher) MatchCorpus(query string, corpus *Corpus) []Match {
+	matches, _ := s.MatchCorpusContext(context.Background(), query, corpus)
+	return matches
+}
+
+// MatchCorpusContext is MatchCorpus that stops as soon as the context is done.
+// The returned error then wraps the error of the context. The matches found
+// before the interruption are returned along with the error if
+// Options.PartialResults is set, otherwise no matches are returned. A
+// ParseError is returned for a malformed query.
+func (s *Searcher) MatchCorpusContext(ctx context.Context, query string, corpus *Corpus) ([]Match, error) {
+	return s.match(ctx, query, corpus.fieldNames(), corpus.snapshot(), s.cacheFor(corpus))
+}
+
+// MatchQueryContext is MatchCorpusContext for a parsed query. The query is
+// matched in extended-search mode regardless of Options.Extended. Parse it
+// with Searcher.ParseQuery to name the fields after the columns of the header
+// of the corpus.
+func (s *Searcher) MatchQueryContext(ctx context.Context, query *Query, corpus *Corpus) ([]Match, error) {
+	pattern, err := buildPattern(
+		s.opts.Fuzzy,
+		s.opts.FuzzyAlgo,
+		s.opts.Algos,
+		s.opts.CustomTerms,
+		true,
+		s.opts.Case,
+		s.opts.Normalize,
+		s.opts.Forward,
+		true,
+		s.opts.Nth,
+		s.opts.Delimiter,
+		s.opts.Tiebreak,
+		s.opts.Scheme,
+		s.opts.FieldScopes,
+		nil,
+		query,
+		nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+	matches, _, err := s.matchPattern(ctx, pattern, corpus.snapshot(), s.cacheFor(corpus))
+	return matches, err
+}
+
+func (s *Searcher) match(ctx context.Context, query string, fields []string, chunks []*chunk, cache *chunkCache) ([]Match, error) {
+	matches, _, err := s.matchStats(ctx, query, fields, chunks, cache)
+	return matches, err
+}
+
+func (s *Searcher) matchStats(ctx context.Context, query string, fields []string, chunks []*chunk, cache *chunkCache) ([]Match, Stats, error) {
+	pattern, err := s.buildPattern(ctx, query, fields)
+	if err != nil {
+		return nil, Stats{}, err
+	}
+	return s.matchPattern(ctx, pattern, chunks, cache)
+}
+
+func (s *Searcher) matchPattern(ctx context.Context, pattern *pattern, chunks []*chunk, cache *chunkCache) ([]Match, Stats, error) {
+	var start time.Time
+	if s.logEnabled(ctx) {
+		start = time.Now()
+	}
+	merger, stats, interrupted := s.scan(ctx, pattern, chunks, cache)
+	stats.Searches = 1
+	defer func() { s.addStats(stats) }()
+	if interrupted && !s.opts.PartialResults {
+		return nil, stats, interruptedError(ctx)
+	}
+
+	// Each partition holds up to Limit results, take the best of them
+	numMatches := merger.Length()
+	if s.opts.Limit > 0 && numMatches > s.opts.Limit {
+		numMatches = s.opts.Limit
+	}
+	sortStart := time.Now()
+	results := make([]result, numMatches)
+	for idx := range results {
+		results[idx] = merger.Get(idx)
+	}
+	stats.SortTime += time.Since(sortStart)
+
+	slab := s.getSlab()
+	defer s.putSlab(slab)
+	matchStart := time.Now()
+	matches := make([]Match, 0, numMatches)
+	for idx, result := range results {
+		// Computing the positions is expensive, so check the context again
+		if !interrupted && idx%chunkSize == 0 && ctx.Err() != nil {
+			interrupted = true
+			break
+		}
+		matches = append(matches, buildMatch(pattern, result, slab))
+	}
+	stats.MatchTime += time.Since(matchStart)
+	if s.logEnabled(ctx) {
+		s.logDebug(ctx, "fzflib: search done",
+			slog.String("query", pattern.AsString()),
+			slog.Int("chunks", len(chunks)),
+			slog.Int("matches", stats.Matches),
+			slog.Int("returned", len(matches)),
+			slog.Bool("interrupted", interrupted),
+			slog.Int("scanned", stats.Scanned),
+			slog.Int("skipped", stats.Skipped),
+			slog.Int("cacheHits", stats.CacheHits),
+			slog.Duration("matchTime", stats.MatchTime),
+			slog.Duration("sortTime", stats.SortTime),
+			slog.Duration("duration", time.Since(start)))
+	}
+	if interrupted {
+		if !s.opts.PartialResults {
+			return nil, stats, interruptedError(ctx)
+		}
+		return matches, stats, interruptedError(ctx)
+	}
+	return matches, stats, nil
+}
+
+func interruptedError(ctx context.Context) error {
+	return fmt.Errorf("fzflib: search interrupted: %w", ctx.Err())
+}
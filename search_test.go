@@ -2,7 +2,19 @@ package fzflib
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+
+	"github.com/bookreport/fzflib/algo"
+	"github.com/bookreport/fzflib/util"
 )
 
 func TestSearchOne(t *testing.T) {
@@ -52,3 +64,648 @@ func TestSearchTwo(t *testing.T) {
 
 	t.Error("expected to find 'When nobody is around, the trees gossip about the people who have walked under them' in result set")
 }
+
+func TestSearcherOptions(t *testing.T) {
+	content := [][]byte{
+		[]byte("foo-bar"),
+		[]byte("fooBar"),
+		[]byte("f-o-o-b-a-r"),
+	}
+
+	opts := DefaultOptions()
+	if result := NewSearcher(opts).Search("fbr", content); len(result) != 3 {
+		t.Errorf("expected 3 fuzzy matches, got %d", len(result))
+	}
+
+	opts.Fuzzy = false
+	result := NewSearcher(opts).Search("oob", content)
+	if len(result) != 1 || !bytes.Equal(result[0], []byte("fooBar")) {
+		t.Errorf("expected only 'fooBar' in exact mode, got %q", result)
+	}
+
+	opts.Case = CaseRespect
+	if result := NewSearcher(opts).Search("oob", content); len(result) != 0 {
+		t.Errorf("expected no case-sensitive match, got %q", result)
+	}
+}
+
+func TestSearcherMatch(t *testing.T) {
+	content := [][]byte{
+		[]byte("fuzzy-finder"),
+		[]byte("no match here"),
+		[]byte("fzf ünïcödé finder"),
+	}
+
+	matches := NewSearcher(DefaultOptions()).Match("ff", content)
+	if len(matches) != 2 {
+		t.Fatalf("expected 2 matches, got %d", len(matches))
+	}
+	for _, match := range matches {
+		if !bytes.Equal(match.Text, content[match.Index]) {
+			t.Errorf("expected %q at index %d, got %q", content[match.Index], match.Index, match.Text)
+		}
+		if match.Score <= 0 {
+			t.Errorf("expected positive score for %q, got %d", match.Text, match.Score)
+		}
+	}
+
+	first := matches[0]
+	if first.Index != 0 {
+		t.Errorf("expected 'fuzzy-finder' to rank first, got %q", first.Text)
+	}
+	if len(first.Positions) != 2 || first.Positions[0] != 0 || first.Positions[1] != 6 {
+		t.Errorf("expected positions [0 6], got %v", first.Positions)
+	}
+	if len(first.Offsets) != 1 || first.Offsets[0] != [2]int32{0, 7} {
+		t.Errorf("expected offsets [[0 7]], got %v", first.Offsets)
+	}
+
+	// Invalid UTF-8 is given back as it was
+	invalid := [][]byte{[]byte("fuzzy\xff-finder")}
+	if matches := NewSearcher(DefaultOptions()).Match("ff", invalid); len(matches) != 1 || !bytes.Equal(matches[0].Original, invalid[0]) {
+		t.Errorf("expected the original content, got %v", matches)
+	}
+	if result := Search("ff", invalid); len(result) != 1 || &result[0][0] != &invalid[0][0] {
+		t.Errorf("expected the given slice, got %q", result)
+	}
+}
+
+func TestSearcherConcurrentQueries(t *testing.T) {
+	content := [][]byte{
+		[]byte("src/fzflib/pattern.go"),
+		[]byte("src/fzflib/search.go"),
+		[]byte("src/fzflib/algo/algo.go"),
+		[]byte("src/fzflib/util/chars.go"),
+		[]byte("README.md"),
+	}
+	queries := []string{"pat", "srch", "algo", "'chars", "md$", "^src !util"}
+
+	searcher := NewSearcher(DefaultOptions())
+	expected := make(map[string][][]byte)
+	for _, query := range queries {
+		expected[query] = NewSearcher(DefaultOptions()).Search(query, content)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		for _, query := range queries {
+			wg.Add(1)
+			go func(query string) {
+				defer wg.Done()
+				if result := searcher.Search(query, content); !reflect.DeepEqual(result, expected[query]) {
+					t.Errorf("%q: expected %q, got %q", query, expected[query], result)
+				}
+			}(query)
+		}
+	}
+	wg.Wait()
+}
+
+func TestSearchersAreIndependent(t *testing.T) {
+	content := [][]byte{
+		[]byte("FooBar"),
+		[]byte("foobar"),
+		[]byte("f-o-o-b-a-r"),
+	}
+
+	respect := DefaultOptions()
+	respect.Case = CaseRespect
+	exact := DefaultOptions()
+	exact.Fuzzy = false
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			if result := Search("foobar", content); len(result) != 3 {
+				t.Errorf("expected 3 matches with the default options, got %q", result)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if result := NewSearcher(respect).Search("foobar", content); len(result) != 2 {
+				t.Errorf("expected 2 case-sensitive matches, got %q", result)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if result := NewSearcher(exact).Search("foobar", content); len(result) != 2 {
+				t.Errorf("expected 2 exact matches, got %q", result)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestFuzzyMatchV2ReusedSlab(t *testing.T) {
+	pattern := []rune("i1go")
+	text := util.ToChars([]byte("item-45/1cf1/239.go"))
+	_, expected := algo.FuzzyMatchV2(false, false, true, &text, pattern, true, util.MakeSlab(slab16Size, slab32Size))
+
+	// The slab keeps the score matrices of the previous item
+	slab := util.MakeSlab(slab16Size, slab32Size)
+	previous := util.ToChars([]byte("item-42/209ea/4310.go"))
+	algo.FuzzyMatchV2(false, false, true, &previous, pattern, false, slab)
+	if _, pos := algo.FuzzyMatchV2(false, false, true, &text, pattern, true, slab); !reflect.DeepEqual(*pos, *expected) {
+		t.Errorf("expected positions %v with a reused slab, got %v", *expected, *pos)
+	}
+}
+
+func TestSearcherParallelMatchesSequential(t *testing.T) {
+	var content [][]byte
+	for i := 0; i < 50*chunkSize+17; i++ {
+		content = append(content, []byte(fmt.Sprintf("item-%d/%x/%d.go", i%97, i*31, i)))
+	}
+
+	sequential := NewSearcher(DefaultOptions())
+	sequential.partitions = 1
+	parallel := NewSearcher(DefaultOptions())
+	parallel.partitions = 8
+
+	for _, query := range []string{"i1go", "item-9 .go$", "'3/ | 4f", "!item", ""} {
+		expected := sequential.Match(query, content)
+		for i := 0; i < 3; i++ {
+			if result := parallel.Match(query, content); !reflect.DeepEqual(result, expected) {
+				t.Fatalf("%q: parallel results differ from sequential results", query)
+			}
+		}
+	}
+}
+
+func TestCorpusRepeatedQueries(t *testing.T) {
+	corpus := NewCorpus()
+	var content [][]byte
+	for i := 0; i < 10*chunkSize; i++ {
+		data := []byte(fmt.Sprintf("%05d/%s", i, strings.Repeat(string(rune('a'+i%26)), 3)))
+		content = append(content, data)
+		corpus.Push(data)
+	}
+	if corpus.Len() != len(content) {
+		t.Fatalf("expected %d items, got %d", len(content), corpus.Len())
+	}
+
+	// Narrowing queries are answered from the results of the previous ones
+	searcher := NewSearcher(DefaultOptions())
+	for _, query := range []string{"b", "bb", "bbb", "0bbb", "bbb", "c", "cc"} {
+		expected := NewSearcher(DefaultOptions()).Match(query, content)
+		if result := searcher.MatchCorpus(query, corpus); !reflect.DeepEqual(result, expected) {
+			t.Errorf("%q: expected %d matches, got %d", query, len(expected), len(result))
+		}
+	}
+}
+
+func TestCorpusPushWhileSearching(t *testing.T) {
+	corpus := NewCorpus()
+	searcher := NewSearcher(DefaultOptions())
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 5*chunkSize; i++ {
+			corpus.Push([]byte(fmt.Sprintf("line %d", i)))
+		}
+	}()
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				searcher.MatchCorpus("line 1", corpus)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if matches := searcher.MatchCorpus("'line 499", corpus); len(matches) != 1 || matches[0].Index != 499 {
+		t.Errorf("expected to find the last pushed item, got %v", matches)
+	}
+}
+
+func TestMatchCorpusContext(t *testing.T) {
+	corpus := NewCorpus()
+	for i := 0; i < 20*chunkSize; i++ {
+		corpus.Push([]byte(fmt.Sprintf("line %d", i)))
+	}
+
+	opts := DefaultOptions()
+	matches, err := NewSearcher(opts).MatchCorpusContext(context.Background(), "line", corpus)
+	if err != nil || len(matches) != corpus.Len() {
+		t.Fatalf("expected %d matches, got %d (%v)", corpus.Len(), len(matches), err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	matches, err = NewSearcher(opts).MatchCorpusContext(ctx, "line", corpus)
+	if !errors.Is(err, context.Canceled) || matches != nil {
+		t.Errorf("expected no matches and context.Canceled, got %d matches (%v)", len(matches), err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 0)
+	defer cancel()
+	opts.PartialResults = true
+	matches, err = NewSearcher(opts).MatchCorpusContext(ctx, "line", corpus)
+	if !errors.Is(err, context.DeadlineExceeded) || len(matches) >= corpus.Len() {
+		t.Errorf("expected partial matches and context.DeadlineExceeded, got %d matches (%v)", len(matches), err)
+	}
+
+	// Cancel while the second chunk is being scanned, the chunk is finished
+	// before the context is checked again
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	var calls atomic.Int32
+	opts.FuzzyAlgo = func(caseSensitive bool, normalize bool, forward bool, text *util.Chars, pattern []rune, withPos bool, slab *util.Slab) (algo.Result, *[]int) {
+		if calls.Add(1) == int32(chunkSize+1) {
+			cancel()
+		}
+		return algo.FuzzyMatchV2(caseSensitive, normalize, forward, text, pattern, withPos, slab)
+	}
+	searcher := NewSearcher(opts)
+	searcher.partitions = 1
+	matches, err = searcher.MatchCorpusContext(ctx, "line", corpus)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	var scanned [][]byte
+	for i := 0; i < 2*chunkSize; i++ {
+		scanned = append(scanned, []byte(fmt.Sprintf("line %d", i)))
+	}
+	if expected := NewSearcher(DefaultOptions()).Match("line", scanned); !reflect.DeepEqual(matches, expected) {
+		t.Errorf("expected the %d matches of the first two chunks, got %d", len(expected), len(matches))
+	}
+}
+
+func TestSearcherLimit(t *testing.T) {
+	var content [][]byte
+	for i := 0; i < 30*chunkSize; i++ {
+		content = append(content, []byte(fmt.Sprintf("%d/%x/%d", i%13, i*7, i)))
+	}
+
+	all := NewSearcher(DefaultOptions()).Match("12", content)
+	for _, limit := range []int{1, 7, 50, len(all) + 10} {
+		opts := DefaultOptions()
+		opts.Limit = limit
+		matches := NewSearcher(opts).Match("12", content)
+		expected := all
+		if limit < len(all) {
+			expected = all[:limit]
+		}
+		if !reflect.DeepEqual(matches, expected) {
+			t.Errorf("limit %d: expected the first %d matches, got %d different matches", limit, len(expected), len(matches))
+		}
+	}
+}
+
+func TestSearcherOf(t *testing.T) {
+	type ticket struct {
+		id    int
+		title string
+	}
+	tickets := []ticket{
+		{101, "Crash when the config file is missing"},
+		{102, "Add dark mode"},
+		{103, "Config reload ignores the file watcher"},
+	}
+
+	searcher := NewSearcherOf(DefaultOptions(), func(t ticket) string { return t.title })
+	matches := searcher.Match("config file", tickets)
+	if len(matches) != 2 {
+		t.Fatalf("expected 2 matches, got %d", len(matches))
+	}
+	for _, match := range matches {
+		if match.Value != tickets[match.Index] || string(match.Text) != match.Value.title {
+			t.Errorf("match %v does not carry its value", match)
+		}
+		if len(match.Positions) == 0 {
+			t.Errorf("expected positions for %q", match.Value.title)
+		}
+	}
+}
+
+func TestSearcherWithNth(t *testing.T) {
+	content := [][]byte{
+		[]byte("1\tsrc/main.go\tmain package"),
+		[]byte("2\tsrc/util.go\thelpers for main"),
+		[]byte("3\tdocs/main.md\tdocumentation"),
+	}
+	opts := DefaultOptions()
+	opts.WithNth, _ = ParseNth("2..")
+	opts.Delimiter = ParseDelimiter(`\t`)
+	opts.Nth = []Range{{1, 1}}
+	searcher := NewSearcher(opts)
+
+	// Nth applies to the transformed items
+	matches := searcher.Match("main", content)
+	if len(matches) != 2 {
+		t.Fatalf("expected 2 matches, got %d", len(matches))
+	}
+	for _, match := range matches {
+		if !bytes.Equal(match.Original, content[match.Index]) {
+			t.Errorf("expected the original content, got %q", match.Original)
+		}
+		if !bytes.Equal(match.Text, content[match.Index][2:]) {
+			t.Errorf("expected the transformed text, got %q", match.Text)
+		}
+	}
+	if matches[0].Index != 0 || !reflect.DeepEqual(matches[0].Positions, []int{4, 5, 6, 7}) {
+		t.Errorf("expected positions in the transformed text, got %v", matches[0])
+	}
+
+	// Search returns the original content
+	if result := searcher.Search("md$", content); len(result) != 1 || !bytes.Equal(result[0], content[2]) {
+		t.Errorf("expected the original content, got %q", result)
+	}
+
+	corpus := searcher.NewCorpus()
+	for _, data := range content {
+		corpus.Push(data)
+	}
+	if matches := searcher.MatchCorpus("^1", corpus); len(matches) != 0 {
+		t.Errorf("expected the removed field not to be searched, got %v", matches)
+	}
+	if matches := searcher.MatchCorpus("'documentation", corpus); len(matches) != 0 {
+		t.Errorf("expected the trailing field to be out of the scope, got %v", matches)
+	}
+
+	// The fields are split once by the corpus, and split again by the
+	// Searchers with another delimiter
+	if item := &corpus.snapshot()[0].items[0]; item.tokens == nil || len(*item.tokens) != 2 {
+		t.Fatalf("expected the fields of the item, got %v", item.tokens)
+	}
+	opts.Delimiter = ParseDelimiter("/")
+	if matches := NewSearcher(opts).MatchCorpus("src", corpus); len(matches) != 2 {
+		t.Errorf("expected the items to be split with the delimiter of the Searcher, got %v", matches)
+	}
+	if matches := NewSearcher(opts).MatchCorpus("main", corpus); len(matches) != 0 {
+		t.Errorf("expected the items to be split with the delimiter of the Searcher, got %v", matches)
+	}
+}
+
+func TestHeaderLines(t *testing.T) {
+	opts := DefaultOptions()
+	opts.HeaderLines = 1
+	opts.Delimiter = CSVDelimiter(',')
+	opts.FieldScopes = true
+	searcher := NewSearcher(opts)
+
+	corpus := searcher.NewCorpus()
+	for _, data := range []string{
+		"Name,City,Note",
+		"\"Doe, John\",Boston,city of beans",
+		"Boston Dynamics,Waltham,robots",
+		"Jane,Austin,note to self",
+	} {
+		corpus.Push([]byte(data))
+	}
+	if corpus.Len() != 3 {
+		t.Errorf("expected the header not to be counted, got %d", corpus.Len())
+	}
+	if header := corpus.Header(); len(header) != 1 || string(header[0]) != "Name,City,Note" {
+		t.Errorf("unexpected header: %q", header)
+	}
+
+	// The header is never matched, but it is counted in the indexes
+	if matches := searcher.MatchCorpus("'note", corpus); len(matches) != 1 || matches[0].Index != 3 {
+		t.Errorf("expected only 'Jane', got %v", matches)
+	}
+
+	// Fields can be named after the columns of the header
+	matches := searcher.MatchCorpus("city:boston", corpus)
+	if len(matches) != 1 || matches[0].Index != 1 || !reflect.DeepEqual(matches[0].Offsets, [][2]int32{{12, 18}}) {
+		t.Errorf("expected only 'John', got %v", matches)
+	}
+	if matches := searcher.MatchCorpus("NAME:^boston", corpus); len(matches) != 1 || matches[0].Index != 2 {
+		t.Errorf("expected only 'Boston Dynamics', got %v", matches)
+	}
+	if matches := searcher.MatchCorpus("unknown:boston", corpus); len(matches) != 0 {
+		t.Errorf("expected unknown fields to be part of the text, got %v", matches)
+	}
+
+	// The Searcher parses queries with the names of the fields of the corpus
+	query, err := searcher.ParseQuery("city:boston", corpus)
+	if err != nil || query.Groups[0].Terms[0].Text != "boston" {
+		t.Fatalf("expected a field-scoped term, got %+v (%v)", query, err)
+	}
+	if matches, err := searcher.MatchQueryContext(context.Background(), query, corpus); err != nil || len(matches) != 1 || matches[0].Index != 1 {
+		t.Errorf("expected only 'John', got %v (%v)", matches, err)
+	}
+	if query, _ := ParseQuery("city:boston", opts); query.Groups[0].Terms[0].Text != "city:boston" {
+		t.Errorf("expected the field name to be part of the text without a corpus, got %+v", query)
+	}
+
+	// Match applies the header lines as well
+	content := [][]byte{[]byte("id name"), []byte("1 alice"), []byte("2 bob")}
+	opts.Delimiter = Delimiter{}
+	if result := NewSearcher(opts).Search("name:b", content); len(result) != 1 || string(result[0]) != "2 bob" {
+		t.Errorf("expected only '2 bob', got %q", result)
+	}
+}
+
+func TestExplain(t *testing.T) {
+	opts := DefaultOptions()
+	opts.FieldScopes = true
+	searcher := NewSearcher(opts)
+	explanation, err := searcher.Explain("fbb", []byte("foo/bar/baz"))
+	if err != nil || explanation == nil {
+		t.Fatalf("expected an explanation, got %v (%v)", explanation, err)
+	}
+	if explanation.Score != 76 || len(explanation.Terms) != 1 || explanation.Terms[0].Score != 76 {
+		t.Fatalf("unexpected explanation:\n%s", explanation)
+	}
+	// Word boundaries at the start and after the delimiters, with gaps
+	// between them
+	chars := explanation.Terms[0].Chars
+	expected := []struct{ index, bonus, gap int }{{0, 20, 0}, {4, 9, -5}, {8, 9, -5}}
+	for idx, e := range expected {
+		c := chars[idx]
+		if c.Index != e.index || c.Match != 16 || c.Bonus != e.bonus || c.Consecutive != 0 || c.Gap != e.gap {
+			t.Errorf("unexpected score of %q: %+v", c.Char, c)
+		}
+	}
+	if !reflect.DeepEqual(explanation.Points, []CriterionPoints{{ByScore, math.MaxUint16 - 76}, {ByLength, 11}}) {
+		t.Errorf("unexpected points: %v", explanation.Points)
+	}
+	if match := searcher.Match("fbb", [][]byte{[]byte("foo/bar/baz")}); match[0].Points != [4]uint16{0, 0, 11, math.MaxUint16 - 76} {
+		t.Errorf("expected the points of the match, got %v", match[0].Points)
+	}
+
+	// The parts add up to the scores of the terms, which are relative to the
+	// fields of scoped terms
+	explanation, _ = searcher.Explain("oBz 2:^ma | xyz !zzz", []byte("fooBarbaz1 main.go"))
+	if explanation == nil || len(explanation.Terms) != 2 || explanation.Terms[1].Term.String() != "2:^ma" ||
+		explanation.Terms[1].Offset != [2]int32{11, 13} {
+		t.Fatalf("unexpected explanation: %+v", explanation)
+	}
+	total := 0
+	for _, term := range explanation.Terms {
+		sum := 0
+		for _, c := range term.Chars {
+			sum += c.Total()
+		}
+		if sum != term.Score || term.Residual != 0 {
+			t.Errorf("%s: expected the parts to add up to %d, got %d", term.Term.String(), term.Score, sum)
+		}
+		total += term.Score
+	}
+	if total != explanation.Score {
+		t.Errorf("expected the terms to add up to %d, got %d", explanation.Score, total)
+	}
+	if c := explanation.Terms[1].Chars[0]; c.Index != 11 || c.Bonus != 20 {
+		t.Errorf("expected the start of the field to be a word boundary, got %+v", c)
+	}
+	if c := explanation.Terms[0].Chars[1]; c.Char != 'B' || c.Bonus != 7 {
+		t.Errorf("expected a camelCase bonus, got %+v", c)
+	}
+	if !strings.Contains(explanation.String(), "'B': match 16, bonus 7, consecutive 0, gap 0") {
+		t.Errorf("unexpected string representation:\n%s", explanation)
+	}
+
+	// The parts and the residuals add up to the score of the match with every
+	// algorithm and scheme
+	for _, scheme := range []*algo.Scheme{algo.DefaultScheme, algo.PathScheme, algo.HistoryScheme} {
+		for _, fuzzyAlgo := range []algo.Algo{scheme.FuzzyMatchV1, scheme.FuzzyMatchV2, scheme.FuzzyMatchTypos(1)} {
+			opts := DefaultOptions()
+			opts.FuzzyAlgo = fuzzyAlgo
+			opts.Scheme = scheme
+			searcher := NewSearcher(opts)
+			for _, test := range [][2]string{
+				{"ab", "aab"}, {"ab", "a_ab"}, {"fbb", "foo/bar/baz"}, {"oBz 'ba ^f", "fooBarbaz1 main.go"},
+				{"^a_ab$", " a_ab"}, {"recieve", "the recive"},
+			} {
+				explanation, _ := searcher.Explain(test[0], []byte(test[1]))
+				matches := searcher.Match(test[0], [][]byte{[]byte(test[1])})
+				if explanation == nil || len(matches) == 0 {
+					if explanation != nil || len(matches) != 0 {
+						t.Errorf("%s: expected an explanation of %q for %q only if it matches", scheme, test[1], test[0])
+					}
+					continue
+				}
+				if explanation.Score != matches[0].Score {
+					t.Errorf("%s: expected the score of the match %d for %q, got %d", scheme, matches[0].Score, test[0], explanation.Score)
+				}
+				total := 0
+				for _, term := range explanation.Terms {
+					sum := term.Residual
+					for _, c := range term.Chars {
+						sum += c.Total()
+					}
+					if sum != term.Score {
+						t.Errorf("%s: expected the parts of %s to add up to %d, got %d", scheme, term.Term.String(), term.Score, sum)
+					}
+					total += term.Score
+				}
+				if total != explanation.Score {
+					t.Errorf("%s: expected the terms of %q to add up to %d, got %d", scheme, test[0], explanation.Score, total)
+				}
+			}
+		}
+	}
+
+	// The parts of the positions of FuzzyMatchV2 do not add up to its score,
+	// and the difference is shown
+	for text, residual := range map[string]int{"aab": -13, "a_ab": 8} {
+		explanation, _ := searcher.Explain("ab", []byte(text))
+		if explanation == nil || explanation.Terms[0].Residual != residual || !strings.Contains(explanation.String(), fmt.Sprintf("residual %d\n", residual)) {
+			t.Errorf("%s: expected a residual of %d, got\n%s", text, residual, explanation)
+		}
+	}
+
+	if explanation, err := searcher.Explain("xyz", []byte("foo")); explanation != nil || err != nil {
+		t.Errorf("expected no explanation, got %v (%v)", explanation, err)
+	}
+	var parseErr *ParseError
+	if _, err := searcher.Explain("foo /(/", []byte("foo")); !errors.As(err, &parseErr) {
+		t.Errorf("expected a ParseError, got %v", err)
+	}
+}
+
+func TestSearcherLogger(t *testing.T) {
+	var buf bytes.Buffer
+	opts := DefaultOptions()
+	opts.Logger = slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	searcher := NewSearcher(opts)
+	// Only the chunks before the last one are cached
+	corpus := NewCorpus()
+	for i := 0; i < 2*chunkSize; i++ {
+		corpus.Push([]byte(fmt.Sprintf("item-%03d", i)))
+	}
+
+	searcher.MatchCorpus("item-01", corpus)
+	searcher.MatchCorpus("item-012", corpus)
+	searcher.MatchCorpus("item-012", corpus)
+	for _, expected := range []string{
+		`msg="fzflib: pattern built" query=item-01 extended=true termSets=1 cacheable=true cacheKey=item-01`,
+		`msg="fzflib: chunk matched" chunk=0 items=100 scanned=100 matches=19 cache=miss`,
+		`msg="fzflib: chunk matched" chunk=0 items=100 scanned=19 matches=1 cache=narrowed`,
+		`msg="fzflib: pattern cache hit" query=item-012`,
+		`msg="fzflib: chunk matched" chunk=0 items=100 scanned=0 matches=1 cache=hit`,
+		`msg="fzflib: search done" query=item-012 chunks=2 matches=1 returned=1 interrupted=false`,
+	} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("expected %s in the log:\n%s", expected, buf.String())
+		}
+	}
+
+	// Nothing is logged above the debug level
+	buf.Reset()
+	opts.Logger = slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	NewSearcher(opts).MatchCorpus("item", corpus)
+	if buf.Len() > 0 {
+		t.Errorf("expected nothing to be logged, got:\n%s", buf.String())
+	}
+
+	// The internals of the algorithm are logged by the scheme
+	opts.Logger = nil
+	opts.Scheme = algo.DefaultScheme.WithLogger(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	NewSearcher(opts).Search("i1", [][]byte{[]byte("item-1")})
+	if !strings.Contains(buf.String(), `msg="fzflib: FuzzyMatchV2 score matrix" pattern=i1`) {
+		t.Errorf("expected the score matrix in the log:\n%s", buf.String())
+	}
+}
+
+func TestSearcherStats(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Limit = 5
+	searcher := NewSearcher(opts)
+	// Only the chunks before the last one are cached
+	corpus := NewCorpus()
+	for i := 0; i < 2*chunkSize; i++ {
+		corpus.Push([]byte(fmt.Sprintf("item-%03d", i)))
+	}
+
+	ctx := context.Background()
+	for _, tc := range []struct {
+		query    string
+		returned int
+		expected Stats
+	}{
+		{"item-01", 5, Stats{Searches: 1, Chunks: 2, Scanned: 200, Matches: 20}},
+		{"item-012", 1, Stats{Searches: 1, Chunks: 2, Scanned: 119, Skipped: 81, Matches: 1}},
+		{"item-012", 1, Stats{Searches: 1, Chunks: 2, CacheHits: 1, Scanned: 100, Matches: 1}},
+	} {
+		matches, stats, err := searcher.MatchCorpusStats(ctx, tc.query, corpus)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(matches) != tc.returned {
+			t.Errorf("%s: expected %d matches, got %d", tc.query, tc.returned, len(matches))
+		}
+		if stats.MatchTime <= 0 {
+			t.Errorf("%s: expected the time spent matching, got %v", tc.query, stats.MatchTime)
+		}
+		stats.MatchTime, stats.SortTime = 0, 0
+		if stats != tc.expected {
+			t.Errorf("%s: expected %+v, got %+v", tc.query, tc.expected, stats)
+		}
+	}
+
+	// The stats of the Searcher add up the stats of every search
+	searcher.MatchCorpus("item", corpus)
+	total := searcher.Stats()
+	total.MatchTime, total.SortTime = 0, 0
+	expected := Stats{Searches: 4, Chunks: 8, CacheHits: 1, Scanned: 619, Skipped: 81, Matches: 222}
+	if total != expected {
+		t.Errorf("expected %+v, got %+v", expected, total)
+	}
+}
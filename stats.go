@@ -0,0 +1,91 @@
+package fzflib
+
+import (
+	"context"
+	"time"
+)
+
+// Stats holds the counters of the work done by searches, either by a single
+// search as returned by MatchCorpusStats or by all the searches of a Searcher
+// as returned by Searcher.Stats
+type Stats struct {
+	// Searches is the number of searches
+	Searches int
+
+	// Chunks is the number of chunks of items that were scanned. The chunks
+	// that were left out when a search was interrupted are not counted.
+	Chunks int
+
+	// CacheHits is the number of chunks whose matches were cached by an
+	// earlier search for the same query, so that none of their items had to
+	// be matched
+	CacheHits int
+
+	// Scanned is the number of items that were matched against the pattern
+	Scanned int
+
+	// Skipped is the number of items that were not matched against the
+	// pattern as they did not match an earlier query that is a prefix or a
+	// suffix of the query. The items of the chunks found in the cache are not
+	// counted.
+	Skipped int
+
+	// Matches is the number of matches found, including those beyond
+	// Options.Limit
+	Matches int
+
+	// MatchTime is the time spent matching the items and computing the
+	// positions of the returned matches. The chunks are matched in parallel,
+	// so it is the sum of the time spent by each goroutine and can be longer
+	// than the search.
+	MatchTime time.Duration
+
+	// SortTime is the time spent sorting the matches and merging the sorted
+	// matches of the goroutines, summed like MatchTime
+	SortTime time.Duration
+}
+
+// add adds the counters of other to the stats
+func (st *Stats) add(other Stats) {
+	st.Searches += other.Searches
+	st.Chunks += other.Chunks
+	st.CacheHits += other.CacheHits
+	st.Scanned += other.Scanned
+	st.Skipped += other.Skipped
+	st.Matches += other.Matches
+	st.MatchTime += other.MatchTime
+	st.SortTime += other.SortTime
+}
+
+// addChunk counts a chunk of the given number of items that was scanned
+func (st *Stats) addChunk(count int, scan chunkScan) {
+	st.Chunks++
+	st.Scanned += scan.scanned
+	switch scan.cache {
+	case cacheHit:
+		st.CacheHits++
+	case cacheNarrowed:
+		st.Skipped += count - scan.scanned
+	}
+}
+
+// Stats returns the sum of the stats of all the searches of the Searcher so
+// far, including the interrupted ones
+func (s *Searcher) Stats() Stats {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.stats
+}
+
+// addStats adds the stats of a search to the stats of the Searcher
+func (s *Searcher) addStats(stats Stats) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.stats.add(stats)
+}
+
+// MatchCorpusStats is MatchCorpusContext that also returns the stats of the
+// search
+func (s *Searcher) MatchCorpusStats(ctx context.Context, query string, corpus *Corpus) ([]Match, Stats, error) {
+	return s.matchStats(ctx, query, corpus.fieldNames(), corpus.snapshot(), s.cacheFor(corpus))
+}
@@ -4,33 +4,155 @@ import (
 	"bytes"
 	"fmt"
 	"regexp"
+	"strconv"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/bookreport/fzflib/util"
 )
 
-// searchCase denotes case-sensitivity of search
-type searchCase int
+// CaseMode denotes case-sensitivity of search
+type CaseMode int
 
-// searchCase-sensitivities
+// Case-sensitivities
 const (
-	searchCaseSmart searchCase = iota
-	searchCaseIgnore
-	searchCaseRespect
+	CaseSmart CaseMode = iota
+	CaseIgnore
+	CaseRespect
 )
 
 const rangeEllipsis = 0
 
-// exprRange represents nth-expression
-type exprRange struct {
-	begin int
-	end   int
+// Range represents nth-expression. Indexes are 1-based, negative indexes
+// count from the last token, and a zero Begin or End leaves that side of the
+// range open as in "..N" or "N..".
+type Range struct {
+	Begin int
+	End   int
+}
+
+// String returns the range in the nth-expression syntax
+func (r Range) String() string {
+	switch {
+	case r.Begin == r.End && r.Begin == rangeEllipsis:
+		return ".."
+	case r.Begin == r.End:
+		return strconv.Itoa(r.Begin)
+	case r.Begin == rangeEllipsis:
+		return ".." + strconv.Itoa(r.End)
+	case r.End == rangeEllipsis:
+		return strconv.Itoa(r.Begin) + ".."
+	}
+	return strconv.Itoa(r.Begin) + ".." + strconv.Itoa(r.End)
+}
+
+// parseRange parses a single nth-expression such as "2", "-1", "2..",
+// "..-2", "1..3" or ".."
+func parseRange(str string) (Range, error) {
+	invalid := fmt.Errorf("invalid nth expression: %q", str)
+	atoi := func(num string) (int, error) {
+		// Unlike --nth of fzf, Atoi accepts a plus sign
+		n, err := strconv.Atoi(num)
+		if err != nil || strings.HasPrefix(num, "+") {
+			return 0, invalid
+		}
+		if n == 0 {
+			return 0, fmt.Errorf("invalid nth expression: %q: field indexes start at 1", str)
+		}
+		return n, nil
+	}
+
+	if str == ".." {
+		return Range{rangeEllipsis, rangeEllipsis}, nil
+	} else if strings.HasPrefix(str, "..") {
+		end, err := atoi(str[2:])
+		if err != nil {
+			return Range{}, err
+		}
+		return Range{rangeEllipsis, end}, nil
+	} else if strings.HasSuffix(str, "..") {
+		begin, err := atoi(str[:len(str)-2])
+		if err != nil {
+			return Range{}, err
+		}
+		return Range{begin, rangeEllipsis}, nil
+	} else if strings.Contains(str, "..") {
+		ns := strings.Split(str, "..")
+		if len(ns) != 2 {
+			return Range{}, invalid
+		}
+		begin, err := atoi(ns[0])
+		if err != nil {
+			return Range{}, err
+		}
+		end, err := atoi(ns[1])
+		if err != nil {
+			return Range{}, err
+		}
+		return Range{begin, end}, nil
+	}
+	n, err := atoi(str)
+	if err != nil {
+		return Range{}, err
+	}
+	return Range{n, n}, nil
+}
+
+// ParseNth parses a comma-separated list of nth-expressions in the format of
+// the --nth option of fzf, e.g. "1,3..5,-1" or "..-2"
+func ParseNth(str string) ([]Range, error) {
+	ranges := []Range{}
+	for _, part := range strings.Split(str, ",") {
+		r, err := parseRange(part)
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, nil
+}
+
+// ParseDelimiter parses the delimiter in the format of the --delimiter option
+// of fzf. "\t" is replaced with a tab. A plain string or a string that is not
+// a valid regular expression is matched literally, and anything else as a
+// regular expression.
+func ParseDelimiter(str string) Delimiter {
+	// Special handling of \t
+	str = strings.Replace(str, "\\t", "\t", -1)
+
+	// 1. Pattern does not contain any special character
+	if regexp.QuoteMeta(str) == str {
+		return StringDelimiter(str)
+	}
+
+	regex, err := regexp.Compile(str)
+	// 2. Pattern is not a valid regular expression
+	if err != nil {
+		return StringDelimiter(str)
+	}
+
+	// 3. Pattern as regular expression. Slow.
+	return RegexDelimiter(regex)
+}
+
+// formatNth returns the comma-separated nth-expressions of the ranges
+func formatNth(ranges []Range) string {
+	parts := make([]string, len(ranges))
+	for idx, r := range ranges {
+		parts[idx] = r.String()
+	}
+	return strings.Join(parts, ",")
 }
 
 // token contains the tokenized part of the strings and its prefix length
 type token struct {
 	text         *util.Chars
 	prefixLength int32
+
+	// rawBegin and rawEnd are the offsets of the part of the strings that
+	// the token was taken from, which includes the quotes around a CSV field
+	rawBegin int32
+	rawEnd   int32
 }
 
 // String returns the string representation of a token.
@@ -38,27 +160,78 @@ func (t token) String() string {
 	return fmt.Sprintf("token{text: %s, prefixLength: %d}", t.text, t.prefixLength)
 }
 
-// inputDelimiter for tokenizing the input
-type inputDelimiter struct {
+// Delimiter for tokenizing the input
+type Delimiter struct {
 	regex *regexp.Regexp
 	str   *string
+	csv   rune
+}
+
+// StringDelimiter returns a Delimiter that splits the input after every
+// occurrence of str
+func StringDelimiter(str string) Delimiter {
+	return Delimiter{str: &str}
+}
+
+// RegexDelimiter returns a Delimiter that splits the input after every match
+// of regex
+func RegexDelimiter(regex *regexp.Regexp) Delimiter {
+	return Delimiter{regex: regex}
+}
+
+// CSVDelimiter returns a Delimiter that splits the input into the fields of a
+// record separated by the separator with the quoting rules of RFC 4180, e.g.
+// ',' for CSV or '\t' for TSV. The tokens are the contents of the fields
+// without the quotes and the separators. Escaped quotes are kept doubled so
+// that the tokens refer to the characters of the input. A range of several
+// fields in Nth, WithNth or a field scope spans the fields as they are written
+// in the input, quotes and separators included.
+func CSVDelimiter(separator rune) Delimiter {
+	return Delimiter{csv: separator}
 }
 
-// String returns the string representation of a inputDelimiter.
-func (d inputDelimiter) String() string {
-	return fmt.Sprintf("inputDelimiter{regex: %v, str: &%q}", d.regex, *d.str)
+// String returns the string representation of a Delimiter.
+func (d Delimiter) String() string {
+	if d.csv != 0 {
+		return fmt.Sprintf("Delimiter{csv: %q}", d.csv)
+	}
+	if d.str == nil {
+		return fmt.Sprintf("Delimiter{regex: %v, str: nil}", d.regex)
+	}
+	return fmt.Sprintf("Delimiter{regex: %v, str: &%q}", d.regex, *d.str)
 }
 
-func withPrefixLengths(tokens []string, begin int) []token {
-	ret := make([]token, len(tokens))
+// tokenize splits apart the given text using the delimiter. The tokens refer
+// to the characters of the text instead of copying them.
+func tokenize(text *util.Chars, delimiter Delimiter) []token {
+	if delimiter.csv != 0 {
+		return csvTokenizer(text, delimiter.csv)
+	}
+
+	if delimiter.str == nil && delimiter.regex == nil {
+		// AWK-style (\S+\s*)
+		return awkTokenizer(text)
+	}
+
+	if delimiter.str != nil {
+		return stringTokenizer(text, *delimiter.str)
+	}
+	return regexTokenizer(text, delimiter.regex)
+}
 
-	prefixLength := begin
+// makeTokens returns the tokens of the text between the given offsets. The
+// characters of the tokens are allocated at once.
+func makeTokens(text *util.Chars, offsets []int) []token {
+	if len(offsets) < 2 {
+		return []token{}
+	}
+	chars := make([]util.Chars, len(offsets)-1)
+	tokens := make([]token, len(chars))
 	for idx := range tokens {
-		chars := util.ToChars([]byte(tokens[idx]))
-		ret[idx] = token{&chars, int32(prefixLength)}
-		prefixLength += chars.Length()
+		chars[idx] = text.Slice(offsets[idx], offsets[idx+1])
+		tokens[idx] = token{&chars[idx], int32(offsets[idx]), int32(offsets[idx]), int32(offsets[idx+1])}
 	}
-	return ret
+	return tokens
 }
 
 const (
@@ -67,21 +240,18 @@ const (
 	awkWhite
 )
 
-func awkTokenizer(input string) ([]string, int) {
+func awkTokenizer(text *util.Chars) []token {
 	// 9, 32
-	ret := []string{}
-	prefixLength := 0
+	offsets := []int{}
 	state := awkNil
 	begin := 0
 	end := 0
-	for idx := 0; idx < len(input); idx++ {
-		r := input[idx]
+	for idx := 0; idx < text.Length(); idx++ {
+		r := text.Get(idx)
 		white := r == 9 || r == 32
 		switch state {
 		case awkNil:
-			if white {
-				prefixLength++
-			} else {
+			if !white {
 				state, begin, end = awkBlack, idx, idx+1
 			}
 		case awkBlack:
@@ -93,124 +263,213 @@ func awkTokenizer(input string) ([]string, int) {
 			if white {
 				end = idx + 1
 			} else {
-				ret = append(ret, input[begin:end])
+				offsets = append(offsets, begin)
 				state, begin, end = awkBlack, idx, idx+1
 			}
 		}
 	}
 	if begin < end {
-		ret = append(ret, input[begin:end])
+		offsets = append(offsets, begin, end)
 	}
-	return ret, prefixLength
+	return makeTokens(text, offsets)
 }
 
-// tokenize splits apart the given string using the delimiter
-func tokenize(text string, delimiter inputDelimiter) []token {
-	if delimiter.str == nil && delimiter.regex == nil {
-		// AWK-style (\S+\s*)
-		tokens, prefixLength := awkTokenizer(text)
-		return withPrefixLengths(tokens, prefixLength)
+// splitAfter splits the text after every delimiter. The delimiters are found
+// in the UTF-8 encoding of the text by find, which returns the [begin, end)
+// byte offsets of the first delimiter in the given bytes or nil. The encoding
+// is only built if the text is not ASCII.
+func splitAfter(text *util.Chars, find func([]byte) []int, trailing bool) []token {
+	data := text.Bytes()
+	if !text.IsBytes() {
+		data = []byte(text.ToString())
 	}
 
-	if delimiter.str != nil {
-		return withPrefixLengths(strings.SplitAfter(text, *delimiter.str), 0)
+	offsets := []int{0}
+	begin := 0
+	for offset := 0; offset < len(data); {
+		loc := find(data[offset:])
+		if loc == nil {
+			break
+		}
+		// Always move forward even if the delimiter is empty
+		last := loc[1]
+		if last == 0 {
+			_, last = utf8.DecodeRune(data[offset:])
+		}
+		end := begin + last
+		if !text.IsBytes() {
+			end = begin + utf8.RuneCount(data[offset:offset+last])
+		}
+		offsets = append(offsets, end)
+		begin = end
+		offset += last
+	}
+	if begin < text.Length() || trailing {
+		offsets = append(offsets, text.Length())
 	}
+	return makeTokens(text, offsets)
+}
 
-	// FIXME performance
-	var tokens []string
-	if delimiter.regex != nil {
-		for len(text) > 0 {
-			loc := delimiter.regex.FindStringIndex(text)
-			if len(loc) < 2 {
-				loc = []int{0, len(text)}
+// stringTokenizer splits the text after every occurrence of the delimiter
+// like strings.SplitAfter
+func stringTokenizer(text *util.Chars, delimiter string) []token {
+	if len(delimiter) == 0 {
+		offsets := make([]int, text.Length()+1)
+		for idx := range offsets {
+			offsets[idx] = idx
+		}
+		return makeTokens(text, offsets)
+	}
+	sep := []byte(delimiter)
+	return splitAfter(text, func(data []byte) []int {
+		if idx := bytes.Index(data, sep); idx >= 0 {
+			return []int{idx, idx + len(sep)}
+		}
+		return nil
+	}, true)
+}
+
+// regexTokenizer splits the text after every match of the regular expression
+func regexTokenizer(text *util.Chars, regex *regexp.Regexp) []token {
+	return splitAfter(text, regex.FindIndex, false)
+}
+
+// csvTokenizer splits the text into the fields of a record in the format of
+// RFC 4180. A field is quoted if it starts with a double quote, and the
+// characters between the closing quote and the next separator are ignored.
+func csvTokenizer(text *util.Chars, separator rune) []token {
+	const quote = '"'
+	length := text.Length()
+	// The bounds of the contents and of the raw fields
+	bounds := [][4]int{}
+	for idx := 0; ; idx++ {
+		rawBegin := idx
+		begin := idx
+		quoted := idx < length && text.Get(idx) == quote
+		if quoted {
+			begin++
+			for idx++; idx < length; idx++ {
+				if text.Get(idx) == quote {
+					// Escaped quote
+					if idx+1 < length && text.Get(idx+1) == quote {
+						idx++
+						continue
+					}
+					break
+				}
 			}
-			last := util.Max(loc[1], 1)
-			tokens = append(tokens, text[:last])
-			text = text[last:]
+		}
+		end := idx
+		for ; idx < length && text.Get(idx) != separator; idx++ {
+		}
+		if !quoted {
+			end = idx
+		}
+		bounds = append(bounds, [4]int{begin, end, rawBegin, idx})
+		if idx >= length {
+			break
 		}
 	}
-	return withPrefixLengths(tokens, 0)
+
+	chars := make([]util.Chars, len(bounds))
+	tokens := make([]token, len(bounds))
+	for idx, bound := range bounds {
+		chars[idx] = text.Slice(bound[0], bound[1])
+		tokens[idx] = token{&chars[idx], int32(bound[0]), int32(bound[2]), int32(bound[3])}
+	}
+	return tokens
 }
 
-func joinTokens(tokens []token) string {
+// fieldNames returns the names of the fields of the header line, which are
+// the tokens without the delimiters and the surrounding whitespaces
+func fieldNames(text *util.Chars, delimiter Delimiter) []string {
+	tokens := tokenize(text, delimiter)
+	names := make([]string, len(tokens))
+	for idx, token := range tokens {
+		name := token.text.ToString()
+		if delimiter.str != nil {
+			name = strings.TrimSuffix(name, *delimiter.str)
+		} else if delimiter.regex != nil {
+			// The token ends with the first match of the delimiter
+			if loc := delimiter.regex.FindStringIndex(name); loc != nil && loc[1] == len(name) {
+				name = name[:loc[0]]
+			}
+		}
+		names[idx] = strings.TrimSpace(name)
+	}
+	return names
+}
+
+// joinTokens joins the tokens transformed from the text. The fields of a CSV
+// record are joined with the separator and keep their quotes so that the
+// result is a record of the same fields.
+func joinTokens(text *util.Chars, tokens []token, delimiter Delimiter) string {
 	var output bytes.Buffer
-	for _, token := range tokens {
-		output.WriteString(token.text.ToString())
+	for idx, token := range tokens {
+		if delimiter.csv == 0 {
+			output.WriteString(token.text.ToString())
+			continue
+		}
+		if idx > 0 {
+			output.WriteRune(delimiter.csv)
+		}
+		raw := text.Slice(int(token.rawBegin), int(token.rawEnd))
+		output.WriteString(raw.ToString())
 	}
 	return output.String()
 }
 
-// transform is used to transform the input when --with-nth option is given
-func transform(tokens []token, withNth []exprRange) []token {
+// transform is used to transform the input when --with-nth option is given.
+// The tokens of a range of several fields are merged into the part of the
+// text that they span, delimiters and quotes included, so that the offsets in
+// the merged token are those of the text.
+func transform(text *util.Chars, tokens []token, withNth []Range) []token {
 	transTokens := make([]token, len(withNth))
 	numTokens := len(tokens)
 	for idx, r := range withNth {
-		parts := []*util.Chars{}
-		minIdx := 0
-		if r.begin == r.end {
-			idx := r.begin
-			if idx == rangeEllipsis {
-				chars := util.ToChars([]byte(joinTokens(tokens)))
-				parts = append(parts, &chars)
-			} else {
-				if idx < 0 {
-					idx += numTokens + 1
-				}
-				if idx >= 1 && idx <= numTokens {
-					minIdx = idx - 1
-					parts = append(parts, tokens[idx-1].text)
-				}
-			}
-		} else {
-			var begin, end int
-			if r.begin == rangeEllipsis { // ..N
-				begin, end = 1, r.end
-				if end < 0 {
-					end += numTokens + 1
-				}
-			} else if r.end == rangeEllipsis { // N..
-				begin, end = r.begin, numTokens
-				if begin < 0 {
-					begin += numTokens + 1
-				}
+		var begin, end int
+		if r.Begin == r.End {
+			begin = r.Begin
+			if begin == rangeEllipsis {
+				begin, end = 1, numTokens
 			} else {
-				begin, end = r.begin, r.end
 				if begin < 0 {
 					begin += numTokens + 1
 				}
-				if end < 0 {
-					end += numTokens + 1
-				}
+				end = begin
 			}
-			minIdx = util.Max(0, begin-1)
-			for idx := begin; idx <= end; idx++ {
-				if idx >= 1 && idx <= numTokens {
-					parts = append(parts, tokens[idx-1].text)
-				}
+		} else if r.Begin == rangeEllipsis { // ..N
+			begin, end = 1, r.End
+			if end < 0 {
+				end += numTokens + 1
 			}
-		}
-		// Merge multiple parts
-		var merged util.Chars
-		switch len(parts) {
-		case 0:
-			merged = util.ToChars([]byte{})
-		case 1:
-			merged = *parts[0]
-		default:
-			var output bytes.Buffer
-			for _, part := range parts {
-				output.WriteString(part.ToString())
+		} else if r.End == rangeEllipsis { // N..
+			begin, end = r.Begin, numTokens
+			if begin < 0 {
+				begin += numTokens + 1
+			}
+		} else {
+			begin, end = r.Begin, r.End
+			if begin < 0 {
+				begin += numTokens + 1
+			}
+			if end < 0 {
+				end += numTokens + 1
 			}
-			merged = util.ToChars(output.Bytes())
 		}
+		begin, end = util.Max(begin, 1), util.Min(end, numTokens)
 
-		var prefixLength int32
-		if minIdx < numTokens {
-			prefixLength = tokens[minIdx].prefixLength
-		} else {
-			prefixLength = 0
+		switch {
+		case begin > end:
+			empty := util.ToChars([]byte{})
+			transTokens[idx] = token{text: &empty}
+		case begin == end:
+			transTokens[idx] = tokens[begin-1]
+		default:
+			first, last := tokens[begin-1], tokens[end-1]
+			merged := text.Slice(int(first.rawBegin), int(last.rawEnd))
+			transTokens[idx] = token{text: &merged, prefixLength: first.rawBegin, rawBegin: first.rawBegin, rawEnd: last.rawEnd}
 		}
-		transTokens[idx] = token{&merged, prefixLength}
 	}
 	return transTokens
 }
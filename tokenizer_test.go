@@ -0,0 +1,156 @@
+package fzflib
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/bookreport/fzflib/util"
+)
+
+func TestTokenize(t *testing.T) {
+	type tokenOf struct {
+		text         string
+		prefixLength int32
+	}
+	for _, tc := range []struct {
+		text      string
+		delimiter Delimiter
+		expected  []tokenOf
+	}{
+		{"", Delimiter{}, []tokenOf{}},
+		{"", StringDelimiter(":"), []tokenOf{{"", 0}}},
+		{"", RegexDelimiter(regexp.MustCompile(":+")), []tokenOf{}},
+		{"\tfoo bar\t baz ", Delimiter{}, []tokenOf{{"foo ", 1}, {"bar\t ", 5}, {"baz ", 10}}},
+		{"Ünï cödé  x ", Delimiter{}, []tokenOf{{"Ünï ", 0}, {"cödé  ", 4}, {"x ", 10}}},
+		{"a,b,,c,", StringDelimiter(","), []tokenOf{{"a,", 0}, {"b,", 2}, {",", 4}, {"c,", 5}, {"", 7}}},
+		{"Ünï:cödé::x", StringDelimiter("::"), []tokenOf{{"Ünï:cödé::", 0}, {"x", 10}}},
+		{"cödé", StringDelimiter(""), []tokenOf{{"c", 0}, {"ö", 1}, {"d", 2}, {"é", 3}}},
+		{"a,b,,c,", RegexDelimiter(regexp.MustCompile(`[,:]\s*`)), []tokenOf{{"a,", 0}, {"b,", 2}, {",", 4}, {"c,", 5}}},
+		{"  abc:  def:ghi  ", RegexDelimiter(regexp.MustCompile(`[,:]\s*`)), []tokenOf{{"  abc:  ", 0}, {"def:", 8}, {"ghi  ", 12}}},
+		{"Ünï:cödé::x", RegexDelimiter(regexp.MustCompile(":+")), []tokenOf{{"Ünï:", 0}, {"cödé::", 4}, {"x", 10}}},
+		{"\tfoo bar\t baz ", RegexDelimiter(regexp.MustCompile(`\s+`)), []tokenOf{{"\t", 0}, {"foo ", 1}, {"bar\t ", 5}, {"baz ", 10}}},
+		{"äb", RegexDelimiter(regexp.MustCompile("x*")), []tokenOf{{"ä", 0}, {"b", 1}}},
+	} {
+		text := util.ToChars([]byte(tc.text))
+		tokens := []tokenOf{}
+		for _, token := range tokenize(&text, tc.delimiter) {
+			tokens = append(tokens, tokenOf{token.text.ToString(), token.prefixLength})
+		}
+		if !reflect.DeepEqual(tokens, tc.expected) {
+			t.Errorf("%q %v: expected %v, got %v", tc.text, tc.delimiter, tc.expected, tokens)
+		}
+	}
+}
+
+func benchmarkContent() [][]byte {
+	content := make([][]byte, 10000)
+	for idx := range content {
+		// Half of the items are not ASCII and are stored as runes
+		name := "Unicode"
+		if idx%2 == 0 {
+			name = "Ünïcödé"
+		}
+		content[idx] = []byte(fmt.Sprintf("%d, user%d@example.com, %s %d, /var/log/app-%d.log, %d", idx, idx*7, name, idx%13, idx%97, idx*31))
+	}
+	return content
+}
+
+func BenchmarkSearchRegexDelimiter(b *testing.B) {
+	opts := DefaultOptions()
+	opts.Delimiter = ParseDelimiter(`,\s*`)
+	opts.Nth, _ = ParseNth("2,-2")
+	searcher := NewSearcher(opts)
+	content := benchmarkContent()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		searcher.Match("usr7 log", content)
+	}
+}
+
+func BenchmarkTokenizeRegex(b *testing.B) {
+	delimiter := ParseDelimiter(`,\s*`)
+	content := benchmarkContent()
+	items := make([]util.Chars, len(content))
+	for idx, data := range content {
+		items[idx] = util.ToChars(data)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for idx := range items {
+			tokenize(&items[idx], delimiter)
+		}
+	}
+}
+
+func TestCSVTokenizer(t *testing.T) {
+	for text, expected := range map[string][]string{
+		``:                     {``},
+		`a`:                    {`a`},
+		`a,b,`:                 {`a`, `b`, ``},
+		`"Doe, John",42`:       {`Doe, John`, `42`},
+		`"say ""hi""",x`:       {`say ""hi""`, `x`},
+		`"",""`:                {``, ``},
+		`"quoted" trailing,x`:  {`quoted`, `x`},
+		`a"b,c`:                {`a"b`, `c`},
+		`"unterminated, field`: {`unterminated, field`},
+		`"Ünï, cödé",é`:        {`Ünï, cödé`, `é`},
+	} {
+		chars := util.ToChars([]byte(text))
+		tokens := tokenize(&chars, CSVDelimiter(','))
+		fields := []string{}
+		for _, token := range tokens {
+			field := token.text.ToString()
+			fields = append(fields, field)
+			// The prefix length is the position of the field in the text
+			if raw := string(chars.ToRunes()[token.prefixLength:]); !strings.HasPrefix(raw, field) {
+				t.Errorf("%q: %q is not at %d", text, field, token.prefixLength)
+			}
+		}
+		if !reflect.DeepEqual(fields, expected) {
+			t.Errorf("%q: expected %q, got %q", text, expected, fields)
+		}
+	}
+
+	content := [][]byte{
+		[]byte("name\tcity\tnote"),
+		[]byte("\"Doe\tJohn\"\tBoston\tdoe"),
+		[]byte("Jane\tDoe\tx"),
+	}
+	opts := DefaultOptions()
+	opts.Delimiter = CSVDelimiter('\t')
+	opts.FieldScopes = true
+	matches := NewSearcher(opts).Match("2:^Doe", content)
+	if len(matches) != 1 || matches[0].Index != 2 {
+		t.Fatalf("expected only 'Jane', got %v", matches)
+	}
+	matches = NewSearcher(opts).Match("1:'doe -1:^doe", content)
+	if len(matches) != 1 || !reflect.DeepEqual(matches[0].Offsets, [][2]int32{{1, 4}, {18, 21}}) {
+		t.Errorf("expected offsets in the raw line, got %v", matches)
+	}
+
+	// A range of several fields spans them as they are written in the line
+	opts = DefaultOptions()
+	opts.Delimiter = CSVDelimiter(',')
+	opts.Nth = []Range{{1, 2}}
+	for query, expected := range map[string][]int{
+		"'John":      {6, 7, 8, 9},
+		"'John\",42": {6, 7, 8, 9, 10, 11, 12, 13},
+		"^\"Doe":     {0, 1, 2, 3},
+		"'ü,\"é\"":   {0, 1, 2, 3, 4},
+	} {
+		matches := NewSearcher(opts).Match(query, [][]byte{[]byte(`"Doe, John",42,"Ünï, cödé",x`), []byte(`ü,"é","",`)})
+		if len(matches) != 1 || !reflect.DeepEqual(matches[0].Positions, expected) {
+			t.Errorf("%s: expected positions %v, got %v", query, expected, matches)
+		}
+	}
+
+	opts.Nth = nil
+	opts.WithNth = []Range{{1, 2}, {-2, -2}}
+	matches = NewSearcher(opts).Match("john", [][]byte{[]byte(`"Doe, John",42,"Ünï, cödé",x`)})
+	if len(matches) != 1 || string(matches[0].Text) != `"Doe, John",42,"Ünï, cödé"` {
+		t.Errorf("expected the fields with their quotes and separators, got %v", matches)
+	}
+}
@@ -88,6 +88,14 @@ func (chars *Chars) Get(i int) rune {
 	return rune(chars.slice[i])
 }
 
+// Slice returns the characters in [begin, end) without copying them
+func (chars *Chars) Slice(begin int, end int) Chars {
+	if runes := chars.optionalRunes(); runes != nil {
+		return RunesToChars(runes[begin:end])
+	}
+	return Chars{slice: chars.slice[begin:end], inBytes: true}
+}
+
 func (chars *Chars) Length() int {
 	if runes := chars.optionalRunes(); runes != nil {
 		return len(runes)